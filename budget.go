@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// *****************************************************************************
+// ***************************** Campaign Budget *******************************
+// Besides the rounds of each phase (see campaignPlan), a campaign can be
+// bounded by wall time and number of executions, or stopped once it stops
+// finding new paths: when the coverage completeness estimated from the f1/f2
+// path frequencies (see listenGlbFreqs) exceeds a threshold, or when the
+// discovery rate (new paths per minute) falls below a floor. Stopping is like
+// an interrupt: all the loops stop and the results are exported. If no rounds
+// are given (-rounds), the last phase runs until the budget is exhausted
+// (unlimited rounds); otherwise, whichever comes first ends the campaign.
+
+type budgetOptions struct {
+	maxTime      time.Duration // 0: no limit.
+	maxExecN     uint64        // 0: no limit.
+	completeness float64       // In %; 0: no limit.
+	minRate      float64       // New paths per minute; 0: no limit.
+}
+
+func (opts budgetOptions) adaptive() bool {
+	return opts.completeness > 0 || opts.minRate > 0
+}
+
+func (opts budgetOptions) bounded() bool {
+	return opts.maxTime > 0 || opts.maxExecN > 0 || opts.adaptive()
+}
+
+var (
+	budgetConf budgetOptions
+	totalExecN uint64 // Executions of all threads (atomic).
+	stopOnce   sync.Once
+)
+
+func countExec() { atomic.AddUint64(&totalExecN, 1) }
+func getExecN() uint64 {
+	return atomic.LoadUint64(&totalExecN)
+}
+
+func stopCampaign(reason string) {
+	stopOnce.Do(func() {
+		fmt.Printf("Stopping the campaign: %s.\n", reason)
+		atomic.AddInt32(&interruptN, 1)
+		intChans.signal(os.Interrupt)
+	})
+}
+
+// ** Coverage progress **
+
+type pathProgress struct {
+	t          time.Time
+	speciesN   int
+	completion float64 // In %.
+}
+
+type progressTracker struct {
+	mtx     sync.Mutex
+	history []pathProgress // Within the last budgetRateWindow, oldest first.
+}
+
+// Updated by listenGlbFreqs.
+var glbProgress = &progressTracker{}
+
+func (pt *progressTracker) add(p pathProgress) {
+	pt.mtx.Lock()
+	defer pt.mtx.Unlock()
+	pt.history = append(pt.history, p)
+	i := 0
+	for i < len(pt.history)-1 &&
+		p.t.Sub(pt.history[i+1].t) >= budgetRateWindow {
+		i++
+	}
+	pt.history = pt.history[i:]
+}
+
+// Latest progress, and discovery rate (paths per minute) over the window.
+// Rate is not ok until a full window was observed.
+func (pt *progressTracker) last() (p pathProgress, rate float64, ok bool) {
+	pt.mtx.Lock()
+	defer pt.mtx.Unlock()
+	if len(pt.history) == 0 {
+		return p, rate, ok
+	}
+	first, p := pt.history[0], pt.history[len(pt.history)-1]
+	if elapsed := p.t.Sub(first.t); elapsed >= budgetRateWindow {
+		rate = float64(p.speciesN-first.speciesN) / elapsed.Minutes()
+		ok = true
+	}
+	return p, rate, ok
+}
+
+// ** Watcher **
+
+func watchBudget(opts budgetOptions) {
+	if opts == (budgetOptions{}) {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(budgetCheckT)
+		defer ticker.Stop()
+		for _ = range ticker.C {
+			if reason, stop := opts.exhausted(); stop {
+				stopCampaign(reason)
+				return
+			}
+		}
+	}()
+}
+
+func (opts budgetOptions) exhausted() (reason string, stop bool) {
+	if opts.maxTime > 0 && time.Since(startT) >= opts.maxTime {
+		return fmt.Sprintf("time budget (%v) reached", opts.maxTime), true
+	} else if opts.maxExecN > 0 && getExecN() >= opts.maxExecN {
+		return fmt.Sprintf("execution budget (%d) reached", opts.maxExecN),
+			true
+	}
+
+	p, rate, okRate := glbProgress.last()
+	if opts.completeness > 0 && p.completion >= opts.completeness &&
+		time.Since(startT) >= budgetRateWindow {
+
+		return fmt.Sprintf("estimated completeness %.1f%% >= %.1f%%",
+			p.completion, opts.completeness), true
+	} else if opts.minRate > 0 && okRate && rate < opts.minRate {
+		return fmt.Sprintf("discovery rate %.2f paths/min < %.2f", rate,
+			opts.minRate), true
+	}
+	return reason, stop
+}
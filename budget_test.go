@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestBudgetExhausted(t *testing.T) {
+	defer func(pt *progressTracker) { glbProgress = pt }(glbProgress)
+	glbProgress = &progressTracker{}
+
+	if _, stop := (budgetOptions{maxTime: time.Nanosecond}).exhausted(); !stop {
+		t.Error("Time budget not exhausted.")
+	}
+	countExec()
+	if _, stop := (budgetOptions{maxExecN: getExecN()}).exhausted(); !stop {
+		t.Error("Execution budget not exhausted.")
+	}
+	if _, stop := (budgetOptions{minRate: 1}).exhausted(); stop {
+		t.Error("Stopped by discovery rate without progress data.")
+	}
+
+	now := time.Now()
+	glbProgress.add(pathProgress{now.Add(-3 * budgetRateWindow), 0, 10})
+	glbProgress.add(pathProgress{now.Add(-2 * budgetRateWindow), 10, 50})
+	glbProgress.add(pathProgress{now, 12, 99})
+	if len(glbProgress.history) != 2 {
+		t.Errorf("%d progress points kept, expected 2.",
+			len(glbProgress.history))
+	}
+	_, rate, ok := glbProgress.last()
+	if !ok || rate != 1 {
+		t.Fatalf("Discovery rate is %v paths/min, expected 1.", rate)
+	}
+	tests := []struct {
+		opts budgetOptions
+		stop bool
+	}{
+		{budgetOptions{minRate: 2}, true},
+		{budgetOptions{minRate: 0.5}, false},
+		{budgetOptions{completeness: 100}, false},
+		{budgetOptions{maxTime: time.Hour, maxExecN: 1 << 62}, false},
+	}
+	for _, test := range tests {
+		if reason, stop := test.opts.exhausted(); stop != test.stop {
+			t.Errorf("Budget %+v: stop is %t (%s).", test.opts, stop, reason)
+		}
+	}
+}
+
+// A budget can run out before a phase's loops start: they're stopped too.
+func TestInterruptLatched(t *testing.T) {
+	multi := newIntMulti()
+	_, early := multi.add()
+	multi.signal(os.Interrupt)
+	multi.signal(os.Interrupt)
+	_, late := multi.add()
+	for name, sigChan := range map[string]chan os.Signal{
+		"early": early, "late": late} {
+
+		if len(sigChan) != 1 {
+			t.Errorf("%s loop got %d signals, expected 1.", name,
+				len(sigChan))
+		}
+	}
+}
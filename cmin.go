@@ -0,0 +1,271 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"flag"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// *****************************************************************************
+// ************************** Corpus Minimization ******************************
+// "hemipt cmin": select a minimal subset of a corpus which keeps all its edges
+// (AFL-cmin like greedy set cover, smallest inputs preferred). Optionally, each
+// region of the global basis (see doGlbProjection) keeps a representative too.
+// Since the global basis needs the PCA of every seed, a PCA is computed on the
+// traces of a few mutants of each input, instead of a full fuzzing campaign.
+
+const (
+	cminCmd        = "cmin"
+	cminPCASampleN = 100 // Mutants per input to compute its PCA (-regions).
+)
+
+type cminEntry struct {
+	runT
+	edges []int
+}
+
+type cminStats struct {
+	inputN, skippedN  int
+	selectedN, edgeN  int
+	regionN, regionRN int // Regions, and representatives added for them.
+	duration          time.Duration
+}
+
+func (stats cminStats) lines() []string {
+	return []string{
+		fmt.Sprintf("input_n: %d", stats.inputN),
+		fmt.Sprintf("skipped_n: %d", stats.skippedN),
+		fmt.Sprintf("selected_n: %d", stats.selectedN),
+		fmt.Sprintf("edge_n: %d", stats.edgeN),
+		fmt.Sprintf("region_n: %d", stats.regionN),
+		fmt.Sprintf("region_representative_n: %d", stats.regionRN),
+		fmt.Sprintf("duration: %v", stats.duration),
+	}
+}
+
+func cmin(args []string) {
+	var cfg toolConfig
+	fs := flag.NewFlagSet(cminCmd, flag.ExitOnError)
+	addToolFlags(fs, &cfg)
+	inDir := fs.String("i", "", "Corpus directory")
+	outDir := fs.String("o", "", "Minimized corpus directory")
+	regions := fs.Bool("regions", false,
+		"Also keep one input per region of the global basis")
+	fs.Parse(args)
+	if len(*inDir) == 0 || len(*outDir) == 0 {
+		fs.Usage()
+		log.Fatal("Please provide input and output directories.")
+	}
+	setCampaignSeed(cfg.randSeed)
+
+	inputs := readSeeds(*inDir)
+	if len(inputs) == 0 {
+		log.Fatal("Empty corpus.")
+	}
+	mt, ok := cfg.startTarget()
+	if !ok {
+		log.Fatal("Couldn't start the PUT.")
+	}
+	defer mt.clean()
+
+	selected, stats := minimizeCorpus(mt, inputs, *regions)
+	createOutDir(*outDir)
+	for _, entry := range selected {
+		path := filepath.Join(*outDir, fmt.Sprintf("%x", entry.hash))
+		err := ioutil.WriteFile(path, entry.input, 0644)
+		if err != nil {
+			log.Printf("Couldn't write input %x: %v.\n", entry.hash, err)
+		}
+	}
+	statLines := stats.lines()
+	saveLines(filepath.Clean(*outDir)+".meta", statLines)
+	fmt.Println(strings.Join(statLines, "\n"))
+}
+
+func minimizeCorpus(mt *managedTarget, inputs [][]byte, regions bool) (
+	selected []cminEntry, stats cminStats) {
+
+	execStartT := time.Now()
+	stats.inputN = len(inputs)
+	entries := runCorpus(mt, inputs)
+	stats.skippedN = len(inputs) - len(entries)
+
+	selIs := coverEdges(entries)
+	if regions {
+		selIs, stats.regionN, stats.regionRN = addRegionReps(mt, entries,
+			selIs)
+	}
+
+	covered := make(map[int]struct{})
+	for _, i := range selIs {
+		selected = append(selected, entries[i])
+		for _, edge := range entries[i].edges {
+			covered[edge] = struct{}{}
+		}
+	}
+	stats.selectedN, stats.edgeN = len(selected), len(covered)
+	stats.duration = time.Since(execStartT)
+	return selected, stats
+}
+
+// Crashing, hanging and duplicate (same trace hash) inputs are skipped: the
+// smallest input of each hash is kept. Entries are sorted by size.
+func runCorpus(mt *managedTarget, inputs [][]byte) (entries []cminEntry) {
+	inputs = append([][]byte{}, inputs...)
+	sort.SliceStable(inputs, func(i, j int) bool {
+		return len(inputs[i]) < len(inputs[j])
+	})
+
+	hashes := make(map[uint64]struct{})
+	for _, input := range inputs {
+		runInfo, ok := mt.safeRun(input, mt.timeout)
+		if !ok || runInfo.crashed || runInfo.hanged {
+			continue
+		} else if _, ok := hashes[runInfo.hash]; ok {
+			continue
+		}
+		hashes[runInfo.hash] = struct{}{}
+
+		runInfo.input = input
+		runInfo.trace = append([]byte{}, mt.getTrace()...)
+		entry := cminEntry{runT: runInfo}
+		for i, tr := range runInfo.trace {
+			if tr != 0 {
+				entry.edges = append(entry.edges, i)
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// Greedy set cover: each edge (rarest first) is covered by the smallest input
+// having it, if no selected input has it yet. Entries must be sorted by size.
+func coverEdges(entries []cminEntry) (selected []int) {
+	best := make(map[int]int) // Edge -> smallest entry having it.
+	counts := make(map[int]int)
+	for i, entry := range entries {
+		for _, edge := range entry.edges {
+			if _, ok := best[edge]; !ok {
+				best[edge] = i
+			}
+			counts[edge]++
+		}
+	}
+	edges := make([]int, 0, len(best))
+	for edge := range best {
+		edges = append(edges, edge)
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		ci, cj := counts[edges[i]], counts[edges[j]]
+		return ci < cj || (ci == cj && edges[i] < edges[j])
+	})
+
+	covered := make(map[int]struct{})
+	for _, edge := range edges {
+		if _, ok := covered[edge]; ok {
+			continue
+		}
+		i := best[edge]
+		selected = append(selected, i)
+		for _, e := range entries[i].edges {
+			covered[e] = struct{}{}
+		}
+	}
+	sort.Ints(selected)
+	return selected
+}
+
+// ** Global basis regions **
+
+// Regions are the projected PCA centers of the entries. The smallest entry of
+// each region without a selected one is added.
+func addRegionReps(mt *managedTarget, entries []cminEntry, selIs []int) (
+	newSelIs []int, regionN, addedN int) {
+
+	var seeds []*seedT
+	seedIs := make(map[*seedT]int)
+	for i, entry := range entries {
+		ok, pca := neighborhoodPCA(mt, entry.input)
+		if !ok {
+			continue
+		}
+		seed := &seedT{
+			runT: entry.runT,
+			exec: &executor{discoveryFit: &pcaFitFunc{dynpca: pca}},
+		}
+		seeds = append(seeds, seed)
+		seedIs[seed] = i
+	}
+	ok, glbProj := doGlbProjection(seeds)
+	if !ok {
+		log.Println("No global basis: regions are ignored.")
+		return selIs, regionN, addedN
+	}
+
+	regions := make([]regionT, len(glbProj.centProjs))
+	for i, centProj := range glbProj.centProjs {
+		regions[i] = makeRegion(centProj.RawRowView(0))
+	}
+	regionN = len(regions)
+	reps := make(map[int]int) // Region -> smallest entry in it.
+	selRegions := make(map[int]struct{})
+	selSet := make(map[int]struct{})
+	for _, i := range selIs {
+		selSet[i] = struct{}{}
+	}
+	for si, seed := range glbProj.cleanedSeeds {
+		i := seedIs[seed]
+		proj := glbProj.seedProjs[si].RawRowView(0)
+		ri := findRegion(regions, proj, seed.hash)
+		if rep, ok := reps[ri]; !ok || i < rep {
+			reps[ri] = i
+		}
+		if _, ok := selSet[i]; ok {
+			selRegions[ri] = struct{}{}
+		}
+	}
+
+	newSelIs = selIs
+	for ri, i := range reps {
+		if _, ok := selRegions[ri]; ok {
+			continue
+		}
+		newSelIs = append(newSelIs, i)
+		addedN++
+	}
+	sort.Ints(newSelIs)
+	return newSelIs, regionN, addedN
+}
+
+// PCA of the traces of random mutants of the input (like the initialization
+// of pcaFitFunc), considered final.
+func neighborhoodPCA(mt *managedTarget, input []byte) (
+	ok bool, pca *dynamicPCA) {
+
+	ig := makeRatioMutator(input, mutationRatio, rand.Int63())
+	var queue [][]byte
+	for i := 0; i < cminPCASampleN; i++ {
+		runInfo, okRun := mt.safeRun(ig.generate(), mt.timeout)
+		if !okRun || runInfo.hanged {
+			continue
+		}
+		queue = append(queue, append([]byte{}, mt.getTrace()...))
+	}
+	if len(queue) <= pcaInitDim {
+		return ok, pca
+	}
+
+	ok, pca = newDynPCA(queue)
+	if ok {
+		pca.phase2, pca.phase4 = false, true
+	}
+	return ok, pca
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCoverEdges(t *testing.T) {
+	entries := []cminEntry{ // Sorted by size.
+		{edges: []int{1, 2}},
+		{edges: []int{2, 3}},
+		{edges: []int{1, 2, 3}},
+		{edges: []int{3, 4}},
+		{edges: []int{1, 4, 5}},
+	}
+	selected := coverEdges(entries)
+	if expected := []int{0, 1, 4}; !reflect.DeepEqual(selected, expected) {
+		t.Errorf("Selected %v, expected %v.", selected, expected)
+	}
+	if selected := coverEdges(nil); len(selected) != 0 {
+		t.Errorf("Selected %v in an empty corpus.", selected)
+	}
+}
+
+func TestMinimizeCorpus(t *testing.T) {
+	mt := startSimTarget(t, aflBackend, putOptions{}, "-persistent",
+		"-depth", "4")
+	defer mt.clean()
+
+	inputs := [][]byte{ // Only the first 4 bytes have branches.
+		[]byte("aaaa"), []byte("aa"), []byte("AA"), []byte("AAaa"),
+		[]byte("CRASH"), []byte("aa"),
+	}
+	selected, stats := minimizeCorpus(mt, inputs, false)
+	if stats.skippedN != 2 { // The crash and the duplicate.
+		t.Errorf("%d inputs skipped, expected 2.", stats.skippedN)
+	}
+	var selInputs []string
+	for _, entry := range selected {
+		selInputs = append(selInputs, string(entry.input))
+	}
+	// Edges of the shorter inputs are covered.
+	if expected := []string{"aaaa", "AAaa"}; !reflect.DeepEqual(selInputs,
+		expected) {
+		t.Errorf("Selected %q, expected %q.", selInputs, expected)
+	}
+
+	// The smallest input of a trace hash is kept, whatever the order.
+	dups := [][]byte{[]byte("aaaa, and useless bytes"), []byte("aaaa")}
+	dupSel, _ := minimizeCorpus(mt, dups, false)
+	if len(dupSel) != 1 || string(dupSel[0].input) != "aaaa" {
+		t.Errorf("Duplicate inputs minimized to %d entries, expected "+
+			"\"aaaa\".", len(dupSel))
+	}
+
+	if testing.Short() {
+		return
+	}
+	withRegions, stats := minimizeCorpus(mt, inputs, true)
+	if stats.regionN == 0 {
+		t.Errorf("Regions not computed: %+v.", stats)
+	}
+	covered := make(map[int]bool)
+	for _, entry := range withRegions {
+		for _, e := range entry.edges {
+			covered[e] = true
+		}
+	}
+	for _, entry := range selected {
+		for _, e := range entry.edges {
+			if !covered[e] {
+				t.Errorf("Edge %d of %q not covered with regions.", e,
+					entry.input)
+			}
+		}
+	}
+
+	// Same seed (-seed): same neighborhoods, hence same regions.
+	defer func(seed int64) { campaignSeed = seed }(campaignSeed)
+	var centers [2][]float64
+	for i := range centers {
+		setCampaignSeed(42)
+		ok, pca := neighborhoodPCA(mt, inputs[0])
+		if !ok {
+			t.Fatal("Couldn't compute neighborhood PCA.")
+		}
+		centers[i] = pca.centers
+	}
+	if !reflect.DeepEqual(centers[0], centers[1]) {
+		t.Error("Neighborhood PCA not reproducible.")
+	}
+}
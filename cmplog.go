@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// *****************************************************************************
+// ******************************** Cmplog *************************************
+// AFL++ cmplog: a companion binary (same program, compiled with
+// AFL_LLVM_CMPLOG) logs the operands of its comparisons in a shared map. The
+// input-to-state mutator then looks for these operands in the seed and
+// replaces them by the value they were compared to (magic values, checksums...).
+// Map layout from AFL++ (4.00c) include/cmplog.h.
+
+const (
+	cmpMapW = 1 << 16
+	cmpMapH = 32
+	// Header: hits:24 id:24 shape:5 type:2 attribute:4 overflow:1 reserved:4.
+	cmpHeaderLen = 8
+	cmpOpLen     = 32 // v0, v1, v0_128, v1_128 (u64).
+	cmpFnOpLen   = 64 // v0[31], v0_len, v1[31], v1_len.
+	cmpMapRtnH   = cmpMapH * cmpOpLen / cmpFnOpLen
+	cmpLogOffset = cmpMapW * cmpHeaderLen
+	cmpMapSize   = cmpLogOffset + cmpMapW*cmpMapH*cmpOpLen
+
+	cmpTypeIns = 1 // Integer comparison instruction.
+	cmpTypeRtn = 2 // Call to a comparison routine (memcmp, strcmp...).
+
+	cmpMaxPairs = 256
+	i2sMaxCands = 1024
+)
+
+// Comparison operands. If numeric, little endian integers of the same width.
+type cmpPair struct {
+	v0, v1  []byte
+	numeric bool
+}
+
+// Run the companion binary on the input and get its comparison operands.
+func cmpOperands(cmpTgt *managedTarget, input []byte) (pairs []cmpPair, ok bool) {
+	put, isAFL := cmpTgt.target.(*aflPutT)
+	if !isAFL || put.cmpMap == nil {
+		return pairs, ok
+	}
+	zeroShm(put.cmpMap[:cmpLogOffset]) // Headers only, like AFL++.
+	_, ok = cmpTgt.safeRun(input, hangConfirmMult*cmpTgt.timeout)
+	if !ok {
+		return pairs, ok
+	}
+	put = cmpTgt.target.(*aflPutT) // Might have been restarted.
+	pairs = parseCmpMap(put.cmpMap)
+	return pairs, ok
+}
+
+func parseCmpMap(cmpMap []byte) (pairs []cmpPair) {
+	seen := make(map[string]struct{})
+	add := func(v0, v1 []byte, numeric bool) {
+		if len(v0) == 0 || bytes.Equal(v0, v1) {
+			return
+		}
+		key := string(v0) + "\x00" + string(v1)
+		if _, ok := seen[key]; ok {
+			return
+		}
+		seen[key] = struct{}{}
+		pair := cmpPair{
+			v0:      append([]byte{}, v0...), // Not in the shared memory.
+			v1:      append([]byte{}, v1...),
+			numeric: numeric,
+		}
+		pairs = append(pairs, pair)
+	}
+
+	for k := 0; k < cmpMapW && len(pairs) < cmpMaxPairs; k++ {
+		header := binary.LittleEndian.Uint64(cmpMap[k*cmpHeaderLen:])
+		hits := int(header & 0xffffff)
+		if hits == 0 {
+			continue
+		}
+		shape := int(header>>48) & 0x1f
+		cmpType := int(header>>53) & 0x3
+		cmpLog := cmpMap[cmpLogOffset+k*cmpMapH*cmpOpLen:]
+
+		switch cmpType {
+		case cmpTypeIns:
+			width := shape + 1
+			if width > 8 { // 128 bits comparisons are ignored.
+				continue
+			}
+			for i := 0; i < hits && i < cmpMapH; i++ {
+				op := cmpLog[i*cmpOpLen:]
+				add(op[:width], op[8:8+width], true)
+			}
+		case cmpTypeRtn:
+			for i := 0; i < hits && i < cmpMapRtnH; i++ {
+				op := cmpLog[i*cmpFnOpLen:]
+				len0, len1 := int(op[31]), int(op[63])
+				if len0 > 31 {
+					len0 = 31
+				}
+				if len1 > 31 {
+					len1 = 31
+				}
+				add(op[:len0], op[32:32+len1], false)
+			}
+		}
+	}
+	return pairs
+}
+
+// *****************************************************************************
+// ************************** Input-to-State Mutator ***************************
+// Deterministic stage: each operand found in the seed is replaced by the one it
+// was compared to (both ways, little and big endian for integers). Once all
+// candidates were generated, the fallback generator takes over.
+
+type i2sCand struct {
+	pos  int
+	repl []byte
+}
+
+type i2sMutator struct {
+	seedIn   []byte
+	fallback inputGen
+
+	analyzed bool
+	cands    []i2sCand
+	next     int
+}
+
+func newI2SMutator(seedIn []byte, fallback inputGen) *i2sMutator {
+	return &i2sMutator{seedIn: seedIn, fallback: fallback}
+}
+
+func (i2s *i2sMutator) generate() (testCase []byte) {
+	if i2s.next >= len(i2s.cands) {
+		return i2s.fallback.generate()
+	}
+	cand := i2s.cands[i2s.next]
+	i2s.next++
+	testCase = make([]byte, len(i2s.seedIn))
+	copy(testCase, i2s.seedIn)
+	copy(testCase[cand.pos:], cand.repl)
+	return testCase
+}
+
+// Partner of the fallback's last test case (none in the deterministic stage).
+func (i2s *i2sMutator) partner() uint64 {
+	if pg, ok := i2s.fallback.(partnerGen); ok && i2s.next >= len(i2s.cands) {
+		return pg.partner()
+	}
+	return 0
+}
+
+func (i2s *i2sMutator) needsCmps() (input []byte, ok bool) {
+	return i2s.seedIn, !i2s.analyzed
+}
+
+func (i2s *i2sMutator) setCmps(pairs []cmpPair) {
+	i2s.cands = i2sCandidates(i2s.seedIn, pairs)
+	i2s.analyzed = true
+}
+
+func i2sCandidates(input []byte, pairs []cmpPair) (cands []i2sCand) {
+	type subst struct{ from, to []byte }
+	var substs []subst
+	for _, pair := range pairs {
+		substs = append(substs, subst{pair.v0, pair.v1}, subst{pair.v1, pair.v0})
+		if pair.numeric && len(pair.v0) > 1 {
+			v0, v1 := reversed(pair.v0), reversed(pair.v1)
+			substs = append(substs, subst{v0, v1}, subst{v1, v0})
+		}
+	}
+
+	for _, sub := range substs {
+		for start := 0; start < len(input); {
+			idx := bytes.Index(input[start:], sub.from)
+			if idx < 0 {
+				break
+			}
+			pos := start + idx
+			repl := sub.to
+			if len(repl) > len(input)-pos { // Test case keeps the seed length.
+				repl = repl[:len(input)-pos]
+			}
+			cands = append(cands, i2sCand{pos: pos, repl: repl})
+			if len(cands) >= i2sMaxCands {
+				return cands
+			}
+			start = pos + 1
+		}
+	}
+	return cands
+}
+
+func reversed(b []byte) []byte {
+	r := make([]byte, len(b))
+	for i, c := range b {
+		r[len(b)-1-i] = c
+	}
+	return r
+}
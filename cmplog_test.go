@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// The simulated target compares input bytes to a magic value: the comparison is
+// logged and one of the input-to-state test cases passes it.
+func TestInputToState(t *testing.T) {
+	const magic = "MAG1C!"
+	cmpTgt := startSimTarget(t, aflBackend, putOptions{cmplog: true},
+		"-persistent", "-magic", magic)
+	defer cmpTgt.clean()
+
+	seedIn := []byte("seed: no magic here")
+	pairs, ok := cmpOperands(cmpTgt, seedIn)
+	if !ok {
+		t.Fatal("Cmplog run failed.")
+	}
+	found := false
+	seedOp := seedIn[simMagicOff : simMagicOff+len(magic)]
+	for _, pair := range pairs {
+		found = found || (bytes.Equal(pair.v0, seedOp) &&
+			bytes.Equal(pair.v1, []byte(magic)))
+	}
+	if !found {
+		t.Fatalf("Magic comparison not logged: %d pairs.", len(pairs))
+	}
+
+	i2s := newI2SMutator(seedIn, seedCopier(seedIn))
+	if _, needed := i2s.needsCmps(); !needed {
+		t.Fatal("Mutator doesn't need comparisons before analysis.")
+	}
+	i2s.setCmps(pairs)
+	if _, needed := i2s.needsCmps(); needed {
+		t.Error("Mutator still needs comparisons after analysis.")
+	}
+	solved := false
+	for range i2s.cands {
+		testCase := i2s.generate()
+		solved = solved || bytes.HasPrefix(testCase[simMagicOff:], []byte(magic))
+	}
+	if !solved {
+		t.Errorf("No test case passes the comparison (%d candidates).",
+			len(i2s.cands))
+	}
+	if testCase := i2s.generate(); !bytes.Equal(testCase, seedIn) {
+		t.Error("Fallback generator not used after the candidates.")
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// *****************************************************************************
+// *************************** CPU Affinity Managing ***************************
+// Each thread (and so its PUT) is pinned to its own CPU. Candidates are either
+// given by the user (-cpus) or ordered from the sysfs topology: one hardware
+// thread per physical core first (hyperthread siblings last), package by
+// package. CPUs other processes are pinned to are avoided. If no CPU is left
+// (or pinning is disabled, e.g. in containers), threads aren't pinned.
+
+const (
+	sysCPUDir  = "/sys/devices/system/cpu"
+	cpuSetSize = 1024 // CPU_SETSIZE
+)
+
+type cpuOptions struct {
+	list  []int // If empty, chosen from the topology.
+	noPin bool
+}
+
+var (
+	cpuConf cpuOptions // Set before starting threads.
+
+	getCPUMtx sync.Mutex
+	usedCPUs  = make(map[int]bool) // By Hemipt threads.
+)
+
+func lockRoutine() (bool, int) {
+	getCPUMtx.Lock()
+	defer getCPUMtx.Unlock()
+
+	runtime.LockOSThread()
+	if cpuConf.noPin {
+		return true, -1
+	}
+
+	targetedCPU := -1
+	busy := make(map[int]bool)
+	if len(cpuConf.list) == 0 { // User choice is not second-guessed.
+		busy = getBusyCPUs()
+	}
+	for _, cpu := range getCandidateCPUs() {
+		if !usedCPUs[cpu] && !busy[cpu] {
+			targetedCPU = cpu
+			break
+		}
+	}
+	if targetedCPU < 0 {
+		log.Println("No CPU available: thread won't be pinned.")
+		return true, -1
+	}
+
+	var set unix.CPUSet
+	set.Zero()
+	set.Set(targetedCPU)
+
+	err := unix.SchedSetaffinity(0, &set)
+	if err != nil {
+		log.Printf("Could not associate PUT with a CPU: %v.\n", err)
+		return true, -1
+	}
+	usedCPUs[targetedCPU] = true
+	return true, targetedCPU
+}
+
+func releaseCPU(cpu int) {
+	getCPUMtx.Lock()
+	delete(usedCPUs, cpu)
+	getCPUMtx.Unlock()
+}
+
+// In order of preference, restricted to the CPUs Hemipt is allowed to run on.
+func getCandidateCPUs() (cands []int) {
+	cands = cpuConf.list
+	if len(cands) == 0 {
+		topo, ok := readCPUTopology(sysCPUDir)
+		if !ok {
+			for cpu := 0; cpu < runtime.NumCPU(); cpu++ {
+				cands = append(cands, cpu)
+			}
+		} else {
+			cands = orderCPUs(topo)
+		}
+	}
+
+	var allowed unix.CPUSet
+	if err := unix.SchedGetaffinity(0, &allowed); err != nil {
+		return cands
+	}
+	var allowedCands []int
+	for _, cpu := range cands {
+		if allowed.IsSet(cpu) {
+			allowedCands = append(allowedCands, cpu)
+		}
+	}
+	return allowedCands
+}
+
+// ** Topology **
+
+type cpuTopo struct {
+	id, pkg     int
+	siblingRank int // Position among the hardware threads of its core.
+}
+
+func readCPUTopology(dir string) (topo []cpuTopo, ok bool) {
+	online, err := ioutil.ReadFile(filepath.Join(dir, "online"))
+	if err != nil {
+		log.Printf("Couldn't read CPU topology: %v.\n", err)
+		return topo, ok
+	}
+	cpus, ok := parseCPUList(string(online))
+	if !ok {
+		return topo, ok
+	}
+
+	for _, cpu := range cpus {
+		topoDir := filepath.Join(dir, fmt.Sprintf("cpu%d", cpu), "topology")
+		pkgStr, err := ioutil.ReadFile(filepath.Join(topoDir,
+			"physical_package_id"))
+		if err != nil {
+			log.Printf("Couldn't read CPU %d package: %v.\n", cpu, err)
+			return topo, false
+		}
+		pkg, err := strconv.Atoi(strings.TrimSpace(string(pkgStr)))
+		if err != nil {
+			log.Printf("Couldn't parse CPU %d package: %v.\n", cpu, err)
+			return topo, false
+		}
+		siblingsStr, err := ioutil.ReadFile(filepath.Join(topoDir,
+			"thread_siblings_list"))
+		if err != nil {
+			log.Printf("Couldn't read CPU %d siblings: %v.\n", cpu, err)
+			return topo, false
+		}
+		siblings, okS := parseCPUList(string(siblingsStr))
+		if !okS {
+			return topo, false
+		}
+
+		var rank int
+		for rank < len(siblings) && siblings[rank] < cpu {
+			rank++
+		}
+		topo = append(topo, cpuTopo{id: cpu, pkg: pkg, siblingRank: rank})
+	}
+	return topo, ok
+}
+
+// First hardware thread of every core, then the second ones, etc.
+func orderCPUs(topo []cpuTopo) (cpus []int) {
+	sorted := make([]cpuTopo, len(topo))
+	copy(sorted, topo)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.siblingRank != b.siblingRank {
+			return a.siblingRank < b.siblingRank
+		} else if a.pkg != b.pkg {
+			return a.pkg < b.pkg
+		}
+		return a.id < b.id
+	})
+	for _, c := range sorted {
+		cpus = append(cpus, c.id)
+	}
+	return cpus
+}
+
+// Kernel list format, e.g. "0-3,8,10-11".
+func parseCPUList(str string) (cpus []int, ok bool) {
+	str = strings.TrimSpace(str)
+	if len(str) == 0 {
+		return cpus, ok
+	}
+	for _, part := range strings.Split(str, ",") {
+		bounds := strings.SplitN(part, "-", 2)
+		start, err := strconv.Atoi(bounds[0])
+		if err != nil || start < 0 {
+			log.Printf("Invalid CPU list %q.\n", str)
+			return cpus, ok
+		}
+		end := start
+		if len(bounds) == 2 {
+			end, err = strconv.Atoi(bounds[1])
+			if err != nil || end < start {
+				log.Printf("Invalid CPU list %q.\n", str)
+				return cpus, ok
+			}
+		}
+		for cpu := start; cpu <= end; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+	ok = true
+	return cpus, ok
+}
+
+// ** Other processes **
+
+func getBusyCPUs() (busyCPUs map[int]bool) {
+	nbCPU := runtime.NumCPU()
+	busyCPUs = make(map[int]bool)
+
+	procDir, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		log.Printf("Could not read /proc: %v.\n", err)
+		return
+	}
+
+	for _, procFileInfo := range procDir {
+		if !procFileInfo.IsDir() { // Only care about dirs
+			continue
+		}
+		name := procFileInfo.Name()
+		if name[0] < '0' || name[0] > '9' { // Only care about pids
+			continue
+		}
+
+		pid, err := strconv.Atoi(name)
+		if err != nil {
+			continue
+		}
+
+		var set unix.CPUSet
+		set.Zero()
+		err = unix.SchedGetaffinity(pid, &set)
+		if err != nil {
+			continue
+		}
+
+		count := set.Count()
+		if count >= nbCPU {
+			continue
+		}
+		status, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/status", pid))
+		if err != nil {
+			log.Printf("Cannot read %s status: %v.\n", name, err)
+			continue
+		}
+		if !strings.Contains(string(status), "VmSize") { // Prob' kernel task
+			continue
+		}
+
+		for cpu := 0; cpu < cpuSetSize; cpu++ {
+			if set.IsSet(cpu) {
+				busyCPUs[cpu] = true
+			}
+		}
+	}
+
+	return busyCPUs
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseCPUList(t *testing.T) {
+	tests := []struct {
+		str  string
+		cpus []int
+		ok   bool
+	}{
+		{"0", []int{0}, true},
+		{"0-3,8,10-11\n", []int{0, 1, 2, 3, 8, 10, 11}, true},
+		{"", nil, false},
+		{"3-1", nil, false},
+		{"a,b", nil, false},
+	}
+	for _, test := range tests {
+		cpus, ok := parseCPUList(test.str)
+		if ok != test.ok || (ok && !reflect.DeepEqual(cpus, test.cpus)) {
+			t.Errorf("parseCPUList(%q) = %v, %v; expected %v, %v.", test.str,
+				cpus, ok, test.cpus, test.ok)
+		}
+	}
+}
+
+// Two packages of two cores with two hardware threads each, numbered like
+// Linux usually does (siblings are n and n+4).
+func TestCPUTopologyOrder(t *testing.T) {
+	dir := t.TempDir()
+	write := func(path, content string) {
+		path = filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("online", "0-7\n")
+	for cpu := 0; cpu < 8; cpu++ {
+		core := cpu % 4
+		topoDir := fmt.Sprintf("cpu%d/topology/", cpu)
+		write(topoDir+"physical_package_id", fmt.Sprintf("%d\n", core/2))
+		write(topoDir+"thread_siblings_list",
+			fmt.Sprintf("%d,%d\n", core, core+4))
+	}
+
+	topo, ok := readCPUTopology(dir)
+	if !ok {
+		t.Fatal("Couldn't read topology.")
+	}
+	expected := []int{0, 1, 2, 3, 4, 5, 6, 7}
+	if cpus := orderCPUs(topo); !reflect.DeepEqual(cpus, expected) {
+		t.Errorf("CPU order is %v, expected %v.", cpus, expected)
+	}
+
+	// Siblings numbered next to each other: hyperthreads come last.
+	for cpu := 0; cpu < 8; cpu++ {
+		write(fmt.Sprintf("cpu%d/topology/thread_siblings_list", cpu),
+			fmt.Sprintf("%d-%d\n", cpu&^1, cpu|1))
+		write(fmt.Sprintf("cpu%d/topology/physical_package_id", cpu),
+			fmt.Sprintf("%d\n", cpu/4))
+	}
+	topo, _ = readCPUTopology(dir)
+	expected = []int{0, 2, 4, 6, 1, 3, 5, 7}
+	if cpus := orderCPUs(topo); !reflect.DeepEqual(cpus, expected) {
+		t.Errorf("CPU order is %v, expected %v.", cpus, expected)
+	}
+}
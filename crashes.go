@@ -0,0 +1,359 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// *****************************************************************************
+// ***************************** Crash Policy **********************************
+// Security policy of the executors: anything the PUT run flagged as a crash
+// (signal or MSAN exit code) is sent to the crash channel. Triage happens
+// later, in the (single) crash collector, which owns the channel.
+// Deduplication: same signal and trace hash is the same crash. Otherwise, the
+// sanitizer report (capture mode) decides or, failing that, new edges.
+
+type crashFitFunc struct{}
+
+func (crashFitFunc) isFit(runInfo runT) bool { return runInfo.crashed }
+func (crashFitFunc) String() string          { return "crash policy" }
+
+// *****************************************************************************
+// **************************** Crash Collector ********************************
+
+var crashColl *crashCollector
+
+type crashKey struct {
+	sig  syscall.Signal
+	hash uint64
+}
+
+type crashCollector struct {
+	dir     string
+	runChan chan runT
+	done    chan struct{} // Closed once runChan is drained.
+	stopper sync.Once
+
+	// Capture mode: crash candidates are re-executed outside the fork server
+	// to get their output and sanitizer report. Nil if not in capture mode.
+	capture *standaloneRunner
+
+	mtx     sync.Mutex
+	keys    map[crashKey]struct{}
+	reports map[string]struct{} // Sanitizer report keys.
+	brMap   map[int]struct{}    // Crash coverage, for the new-edge criterion.
+	totalN  int
+	uniqN   int
+}
+
+// What the capture mode got from a crash candidate.
+type crashCapture struct {
+	done     bool
+	res      standaloneRun
+	report   sanReport
+	okReport bool
+}
+
+func startCrashCollector(outDir string, capture *standaloneRunner) (ok bool) {
+	crashColl.stop() // Of a previous campaign (tests).
+	crashColl = nil
+	dir := filepath.Join(outDir, "crashes")
+	err := os.Mkdir(dir, 0755)
+	if err != nil {
+		log.Printf("Couldn't create crash directory: %v.\n", err)
+		return ok
+	}
+
+	crashColl = &crashCollector{
+		dir:     dir,
+		runChan: make(chan runT, 100),
+		done:    make(chan struct{}),
+		capture: capture,
+		keys:    make(map[crashKey]struct{}),
+		reports: make(map[string]struct{}),
+		brMap:   make(map[int]struct{}),
+	}
+	go crashColl.listen()
+
+	ok = true
+	return ok
+}
+
+// Where the executors send crashes. Without collector, they're dropped.
+func (cc *crashCollector) channel() chan runT {
+	if cc == nil {
+		return devNullFitChan
+	}
+	return cc.runChan
+}
+
+// Once no executor can send crashes anymore: waits for the pending ones.
+func (cc *crashCollector) stop() {
+	if cc == nil {
+		return
+	}
+	cc.stopper.Do(func() { close(cc.runChan) })
+	<-cc.done
+}
+
+func (cc *crashCollector) listen() {
+	defer close(cc.done)
+	for runInfo := range cc.runChan {
+		foundT := time.Now()
+		cc.mtx.Lock()
+		cc.totalN++
+		isNew := cc.isNewTrace(runInfo)
+		cc.mtx.Unlock()
+		if !isNew { // Same crash (signal) and same path: don't even reproduce.
+			continue
+		}
+
+		capt := cc.reproduce(runInfo.input)
+		cc.mtx.Lock()
+		if capt.okReport {
+			isNew = cc.isNewReport(capt.report)
+		} else {
+			isNew = cc.isNewEdge(runInfo)
+		}
+		if isNew {
+			cc.uniqN++
+		}
+		id := cc.uniqN
+		cc.mtx.Unlock()
+
+		if isNew {
+			cc.save(id, runInfo, foundT, capt)
+		}
+	}
+}
+
+func (cc *crashCollector) reproduce(input []byte) (capt crashCapture) {
+	if cc.capture == nil {
+		return capt
+	}
+	res, err := cc.capture.run(input, captureTimeout)
+	if err != nil {
+		return capt
+	}
+	capt.done, capt.res = true, res
+	if res.crashed {
+		capt.report, capt.okReport = parseSanReport(res.stderr)
+	}
+	return capt
+}
+
+// isNew* have to be called with the mutex locked.
+func (cc *crashCollector) isNewTrace(runInfo runT) (isNew bool) {
+	key := crashKey{sig: runInfo.sig, hash: runInfo.hash}
+	if _, ok := cc.keys[key]; ok {
+		return isNew
+	}
+	cc.keys[key] = struct{}{}
+	isNew = true
+	return isNew
+}
+
+func (cc *crashCollector) isNewReport(report sanReport) (isNew bool) {
+	key := report.key()
+	if _, ok := cc.reports[key]; ok {
+		return isNew
+	}
+	cc.reports[key] = struct{}{}
+	isNew = true
+	return isNew
+}
+
+func (cc *crashCollector) isNewEdge(runInfo runT) (isNew bool) {
+	if !crashDedupByEdge {
+		isNew = true
+		return isNew
+	}
+	// AFL-like: only unique if it triggers an edge no other crash triggered.
+	for i, tr := range runInfo.trace {
+		if tr == 0 {
+			continue
+		}
+		if _, ok := cc.brMap[i]; !ok {
+			isNew = true
+			cc.brMap[i] = struct{}{}
+		}
+	}
+	return isNew
+}
+
+func (cc *crashCollector) save(id int, runInfo runT, foundT time.Time,
+	capt crashCapture) {
+
+	name := fmt.Sprintf("%06d-sig%02d-%x", id, int(runInfo.sig), runInfo.hash)
+	meta := []string{
+		fmt.Sprintf("signal: %d (%v)", int(runInfo.sig), runInfo.sig),
+		fmt.Sprintf("wait_status: 0x%x", uint32(runInfo.status)),
+		fmt.Sprintf("exit_status: %d", runInfo.status.ExitStatus()),
+	}
+	meta = append(meta, runMeta(runInfo, foundT)...)
+	if capt.done {
+		meta = append(meta, fmt.Sprintf("reproduced: %t", capt.res.crashed))
+	}
+	saveFault(cc.dir, name, runInfo.input, meta)
+
+	if !capt.done {
+		return
+	}
+	path := filepath.Join(cc.dir, name)
+	if capt.okReport {
+		saveLines(path+".report", capt.report.lines())
+	}
+	if len(capt.res.stdout) > 0 {
+		saveCapture(path+".stdout", capt.res.stdout)
+	}
+	if len(capt.res.stderr) > 0 {
+		saveCapture(path+".stderr", capt.res.stderr)
+	}
+}
+
+func (cc *crashCollector) counts() (totalN, uniqN int) {
+	if cc == nil {
+		return totalN, uniqN
+	}
+	cc.mtx.Lock()
+	totalN, uniqN = cc.totalN, cc.uniqN
+	cc.mtx.Unlock()
+	return totalN, uniqN
+}
+
+// *****************************************************************************
+// ***************************** Hang Collector ********************************
+// Hangs are confirmed by the executor (re-run with a longer timeout) before
+// being sent here. Unique if the trace hash is new.
+
+var hangColl *hangCollector
+
+type hangCollector struct {
+	dir     string
+	runChan chan runT
+	done    chan struct{} // Closed once runChan is drained.
+	stopper sync.Once
+
+	mtx    sync.Mutex
+	hashes map[uint64]struct{}
+	totalN int
+	uniqN  int
+}
+
+func startHangCollector(outDir string) (ok bool) {
+	hangColl.stop() // Of a previous campaign (tests).
+	hangColl = nil
+	dir := filepath.Join(outDir, "hangs")
+	err := os.Mkdir(dir, 0755)
+	if err != nil {
+		log.Printf("Couldn't create hang directory: %v.\n", err)
+		return ok
+	}
+
+	hangColl = &hangCollector{
+		dir:     dir,
+		runChan: make(chan runT, 100),
+		done:    make(chan struct{}),
+		hashes:  make(map[uint64]struct{}),
+	}
+	go hangColl.listen()
+
+	ok = true
+	return ok
+}
+
+func (hc *hangCollector) channel() chan runT {
+	if hc == nil {
+		return devNullFitChan
+	}
+	return hc.runChan
+}
+
+func (hc *hangCollector) stop() {
+	if hc == nil {
+		return
+	}
+	hc.stopper.Do(func() { close(hc.runChan) })
+	<-hc.done
+}
+
+func (hc *hangCollector) listen() {
+	defer close(hc.done)
+	for runInfo := range hc.runChan {
+		foundT := time.Now()
+		hc.mtx.Lock()
+		hc.totalN++
+		_, seen := hc.hashes[runInfo.hash]
+		if !seen {
+			hc.hashes[runInfo.hash] = struct{}{}
+			hc.uniqN++
+		}
+		id := hc.uniqN
+		hc.mtx.Unlock()
+
+		if seen {
+			continue
+		}
+		name := fmt.Sprintf("%06d-%x", id, runInfo.hash)
+		saveFault(hc.dir, name, runInfo.input, runMeta(runInfo, foundT))
+	}
+}
+
+func (hc *hangCollector) counts() (totalN, uniqN int) {
+	if hc == nil {
+		return totalN, uniqN
+	}
+	hc.mtx.Lock()
+	totalN, uniqN = hc.totalN, hc.uniqN
+	hc.mtx.Unlock()
+	return totalN, uniqN
+}
+
+// *****************************************************************************
+// ******************************** Helpers ************************************
+
+func runMeta(runInfo runT, foundT time.Time) []string {
+	return []string{
+		fmt.Sprintf("hash: 0x%x", runInfo.hash),
+		fmt.Sprintf("parent_hash: 0x%x", runInfo.parent),
+		fmt.Sprintf("partner_hash: 0x%x", runInfo.partner),
+		fmt.Sprintf("exec_time: %v", runInfo.execTime),
+		fmt.Sprintf("time_found: %s", foundT.Format(time.RFC3339)),
+		fmt.Sprintf("time_since_start: %v", foundT.Sub(startT)),
+		fmt.Sprintf("input_len: %d", len(runInfo.input)),
+	}
+}
+
+// Write the input and its metadata sidecar (<name>.meta).
+func saveFault(dir, name string, input []byte, meta []string) {
+	path := filepath.Join(dir, name)
+	err := ioutil.WriteFile(path, input, 0644)
+	if err != nil {
+		log.Printf("Couldn't write %s: %v.\n", path, err)
+		return
+	}
+	saveLines(path+".meta", meta)
+}
+
+func saveLines(path string, lines []string) {
+	str := strings.Join(lines, "\n") + "\n"
+	err := ioutil.WriteFile(path, []byte(str), 0644)
+	if err != nil {
+		log.Printf("Couldn't write %s: %v.\n", path, err)
+	}
+}
+
+func saveCapture(path string, out []byte) {
+	err := ioutil.WriteFile(path, out, 0644)
+	if err != nil {
+		log.Printf("Couldn't write %s: %v.\n", path, err)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func crashRun(sig syscall.Signal, hash uint64, edges ...int) runT {
+	runInfo := runT{crashed: true, sig: sig, hash: hash,
+		trace: make([]byte, 8), input: []byte("crash")}
+	for _, e := range edges {
+		runInfo.trace[e] = 1
+	}
+	return runInfo
+}
+
+// Without sanitizer report: same signal and hash, then new edges decide.
+func TestCrashCollectorDedup(t *testing.T) {
+	outDir := t.TempDir()
+	if !startCrashCollector(outDir, nil) {
+		t.Fatal("Couldn't start crash collector.")
+	}
+	defer crashColl.stop()
+
+	runs := []runT{
+		crashRun(syscall.SIGSEGV, 1, 1),    // Unique.
+		crashRun(syscall.SIGSEGV, 1, 1),    // Same signal and hash.
+		crashRun(syscall.SIGABRT, 1, 1),    // Other signal, but no new edge.
+		crashRun(syscall.SIGSEGV, 2, 1),    // Other hash, but no new edge.
+		crashRun(syscall.SIGSEGV, 3, 1, 2), // New edge: unique.
+	}
+	for _, runInfo := range runs {
+		crashColl.channel() <- runInfo
+	}
+	cc := crashColl
+	cc.stop()
+	if totalN, uniqN := cc.counts(); totalN != len(runs) || uniqN != 2 {
+		t.Errorf("%d crashes (%d unique), expected %d (2 unique).", totalN,
+			uniqN, len(runs))
+	}
+	infos, err := ioutil.ReadDir(filepath.Join(outDir, "crashes"))
+	if err != nil {
+		t.Fatalf("Couldn't read crash directory: %v.", err)
+	} else if len(infos) != 2*2 { // Inputs and metadata.
+		t.Errorf("%d files in crash directory, expected 4.", len(infos))
+	}
+}
+
+// With a sanitizer report, its key decides (not the edges).
+func TestCrashCollectorReports(t *testing.T) {
+	cc := &crashCollector{reports: make(map[string]struct{})}
+	asan, _ := parseSanReport([]byte(asanReport))
+	ubsan, _ := parseSanReport([]byte(ubsanReport))
+	for i, test := range []struct {
+		report sanReport
+		isNew  bool
+	}{{asan, true}, {asan, false}, {ubsan, true}} {
+		if isNew := cc.isNewReport(test.report); isNew != test.isNew {
+			t.Errorf("Report %d: new is %t, expected %t.", i, isNew,
+				test.isNew)
+		}
+	}
+}
+
+func TestHangCollectorDedup(t *testing.T) {
+	if !startHangCollector(t.TempDir()) {
+		t.Fatal("Couldn't start hang collector.")
+	}
+	defer hangColl.stop()
+
+	for _, hash := range []uint64{1, 1, 2} {
+		hangColl.channel() <- runT{hanged: true, hash: hash}
+	}
+	hc := hangColl
+	hc.stop()
+	if totalN, uniqN := hc.counts(); totalN != 3 || uniqN != 2 {
+		t.Errorf("%d hangs (%d unique), expected 3 (2 unique).", totalN,
+			uniqN)
+	}
+}
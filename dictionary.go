@@ -0,0 +1,312 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"bytes"
+	"debug/elf"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// *****************************************************************************
+// ****************************** Dictionaries *********************************
+// Tokens (keywords, magic values...) the havoc mutator inserts in or writes
+// over test cases. Sources:
+//  - User dictionary (-x): AFL/libFuzzer dictionary file, or directory where
+//    each file is a token.
+//  - Printable strings of the PUT binary read-only data, and the constants its
+//    code compares to (x86 cmp immediates and 64-bit movabs).
+//  - AFL++ auto dictionary: constants extracted at compile time, sent by the
+//    fork server.
+
+const (
+	dictMaxLen     = 128 // Longer tokens are ignored (like AFL).
+	autoTokenMin   = 4
+	autoTokenMax   = 32
+	autoTokenMaxN  = 1024
+	autoConstMaxN  = 256
+	autodictMaxLen = 0xffffff
+)
+
+// Once the PUT was started (automatic tokens are collected).
+func buildDict(userDict [][]byte, noAuto bool) (dict [][]byte) {
+	dict = append(dict, userDict...)
+	if !noAuto {
+		dict = append(dict, getAutoTokens()...)
+	}
+	dict = dedupTokens(dict)
+	if len(dict) > 0 {
+		fmt.Printf("Dictionary: %d tokens (%d from the user).\n",
+			len(dict), len(userDict))
+	}
+	return dict
+}
+
+func dedupTokens(tokens [][]byte) (deduped [][]byte) {
+	seen := make(map[string]struct{})
+	for _, tok := range tokens {
+		if _, ok := seen[string(tok)]; ok || len(tok) == 0 {
+			continue
+		}
+		seen[string(tok)] = struct{}{}
+		deduped = append(deduped, tok)
+	}
+	return deduped
+}
+
+// ** User Dictionary **
+
+func loadDict(path string) (dict [][]byte, ok bool) {
+	info, err := os.Stat(path)
+	if err != nil {
+		log.Printf("Couldn't open dictionary: %v.\n", err)
+		return dict, ok
+	}
+
+	if info.IsDir() {
+		infos, err := ioutil.ReadDir(path)
+		if err != nil {
+			log.Printf("Couldn't read dictionary directory: %v.\n", err)
+			return dict, ok
+		}
+		for _, info := range infos {
+			if info.IsDir() || info.Size() > dictMaxLen {
+				continue
+			}
+			tok, err := ioutil.ReadFile(filepath.Join(path, info.Name()))
+			if err != nil {
+				log.Printf("Couldn't read dictionary token: %v.\n", err)
+				return dict, ok
+			}
+			dict = append(dict, tok)
+		}
+		ok = true
+		return dict, ok
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Printf("Couldn't read dictionary: %v.\n", err)
+		return dict, ok
+	}
+	return parseDict(string(content))
+}
+
+// Lines are: [name][@level]="value", with \\, \" and \xNN escapes. Comments
+// start with #.
+func parseDict(content string) (dict [][]byte, ok bool) {
+	for i, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		start := strings.IndexByte(line, '"')
+		end := strings.LastIndexByte(line, '"')
+		if start < 0 || end <= start {
+			log.Printf("Malformed dictionary line %d: %s\n", i+1, line)
+			return dict, ok
+		}
+		tok, okTok := unescapeToken(line[start+1 : end])
+		if !okTok {
+			log.Printf("Bad escape in dictionary line %d: %s\n", i+1, line)
+			return dict, ok
+		}
+		if len(tok) <= dictMaxLen {
+			dict = append(dict, tok)
+		}
+	}
+	ok = true
+	return dict, ok
+}
+
+func unescapeToken(str string) (tok []byte, ok bool) {
+	for i := 0; i < len(str); i++ {
+		if str[i] != '\\' {
+			tok = append(tok, str[i])
+			continue
+		}
+		i++
+		if i >= len(str) {
+			return tok, ok
+		}
+		switch str[i] {
+		case '\\', '"':
+			tok = append(tok, str[i])
+		case 'x':
+			if i+2 >= len(str) {
+				return tok, ok
+			}
+			b, err := strconv.ParseUint(str[i+1:i+3], 16, 8)
+			if err != nil {
+				return tok, ok
+			}
+			tok = append(tok, byte(b))
+			i += 2
+		default:
+			return tok, ok
+		}
+	}
+	ok = true
+	return tok, ok
+}
+
+// ** Automatic Tokens **
+// Collected from the first PUT started (binary read by getExtraEnvs, auto
+// dictionary from its fork server).
+
+var (
+	autoTokens    [][]byte
+	autoTokenSrcs = make(map[string]bool) // Sources already collected.
+	autoTokenMtx  sync.Mutex
+)
+
+func addAutoTokens(src string, extract func() [][]byte) {
+	autoTokenMtx.Lock()
+	defer autoTokenMtx.Unlock()
+	if autoTokenSrcs[src] {
+		return
+	}
+	autoTokenSrcs[src] = true
+	autoTokens = append(autoTokens, extract()...)
+}
+
+func getAutoTokens() [][]byte {
+	autoTokenMtx.Lock()
+	defer autoTokenMtx.Unlock()
+	return autoTokens
+}
+
+// Printable strings of the read-only data (whole file if not an ELF), and
+// constants of the code (x86 ELF only).
+func binaryTokens(binContent []byte) (tokens [][]byte) {
+	data := binContent
+	var code []byte
+	if f, err := elf.NewFile(bytes.NewReader(binContent)); err == nil {
+		if sec := f.Section(".rodata"); sec != nil {
+			if secData, err := sec.Data(); err == nil {
+				data = secData
+			}
+		}
+		x86 := f.Machine == elf.EM_X86_64 || f.Machine == elf.EM_386
+		if sec := f.Section(".text"); sec != nil && x86 {
+			code, _ = sec.Data()
+		}
+	}
+	tokens = printableTokens(data)
+	return append(tokens, cmpConstants(code)...)
+}
+
+func printableTokens(data []byte) (tokens [][]byte) {
+
+	seen := make(map[string]struct{})
+	start := -1
+	for i := 0; i <= len(data) && len(tokens) < autoTokenMaxN; i++ {
+		if i < len(data) && data[i] >= 0x20 && data[i] < 0x7f {
+			if start < 0 {
+				start = i
+			}
+			continue
+		} else if start < 0 {
+			continue
+		}
+		str := data[start:i]
+		start = -1
+		if len(str) < autoTokenMin || len(str) > autoTokenMax {
+			continue
+		}
+		if _, ok := seen[string(str)]; ok {
+			continue
+		}
+		seen[string(str)] = struct{}{}
+		tokens = append(tokens, append([]byte{}, str...))
+	}
+	return tokens
+}
+
+// Immediate operands of cmp instructions (with eax/ax, or a register or
+// memory operand), and of movabs (64-bit constants are compared from a
+// register), found by a linear scan of the code: some are false positives.
+// Little endian, like the compared memory. Only constants with at least two
+// distinct bytes other than 0x00 and 0xff are kept (not lengths, masks...).
+func cmpConstants(code []byte) (consts [][]byte) {
+	seen := make(map[string]struct{})
+	for i := 0; i < len(code) && len(consts) < autoConstMaxN; i++ {
+		j, immN, immI := i, 4, -1
+		if code[j] == 0x66 { // Operand size prefix: 16-bit.
+			j, immN = j+1, 2
+		} else if code[j]&0xf0 == 0x40 { // REX prefix.
+			if code[j]&0x08 != 0 && j+1 < len(code) &&
+				code[j+1]&0xf8 == 0xb8 {
+				immI, immN = j+2, 8 // movabs reg, imm64
+			}
+			j++
+		}
+		if immI < 0 && j+1 < len(code) {
+			if code[j] == 0x3d { // cmp (e)ax, imm
+				immI = j + 1
+			} else if code[j] == 0x81 && (code[j+1]>>3)&7 == 7 { // cmp r/m, imm
+				immI = j + 2 + modRMExtraN(code[j+1])
+			}
+		}
+		if immI < 0 || immI+immN > len(code) {
+			continue
+		}
+
+		// Only the prefix is skipped: a false positive may overlap the next
+		// instruction.
+		imm := code[immI : immI+immN]
+		i = j
+		if _, ok := seen[string(imm)]; ok || !interestingConst(imm) {
+			continue
+		}
+		seen[string(imm)] = struct{}{}
+		consts = append(consts, append([]byte{}, imm...))
+	}
+	return consts
+}
+
+// SIB and displacement bytes after the ModRM byte.
+func modRMExtraN(modRM byte) (n int) {
+	mod, rm := modRM>>6, modRM&7
+	if mod != 3 && rm == 4 {
+		n++
+	}
+	switch {
+	case mod == 0 && rm == 5: // RIP-relative.
+		n += 4
+	case mod == 1:
+		n++
+	case mod == 2:
+		n += 4
+	}
+	return n
+}
+
+func interestingConst(imm []byte) bool {
+	distinct := make(map[byte]struct{})
+	for _, b := range imm {
+		if b != 0 && b != 0xff {
+			distinct[b] = struct{}{}
+		}
+	}
+	return len(distinct) >= 2
+}
+
+// Entries are a length byte followed by the token.
+func parseAutodict(data []byte) (tokens [][]byte) {
+	for i := 0; i < len(data); {
+		n := int(data[i])
+		if i+1+n > len(data) {
+			break
+		}
+		tokens = append(tokens, append([]byte{}, data[i+1:i+1+n]...))
+		i += 1 + n
+	}
+	return tokens
+}
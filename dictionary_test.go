@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestParseDict(t *testing.T) {
+	content := `# Comment
+kw1="GET"
+kw2@1="\x00\x01binary\"quote\\"
+
+"no name"
+`
+	dict, ok := parseDict(content)
+	if !ok {
+		t.Fatal("Couldn't parse dictionary.")
+	}
+	expected := [][]byte{
+		[]byte("GET"),
+		[]byte("\x00\x01binary\"quote\\"),
+		[]byte("no name"),
+	}
+	if !reflect.DeepEqual(dict, expected) {
+		t.Errorf("Dictionary is %q, expected %q.", dict, expected)
+	}
+
+	for _, bad := range []string{`kw="unfinished`, `kw="\x0"`, `kw="\n"`} {
+		if _, ok := parseDict(bad); ok {
+			t.Errorf("Malformed dictionary %q was accepted.", bad)
+		}
+	}
+}
+
+// The simulated fork server offers an auto dictionary, which is then used by
+// the havoc mutator.
+func TestAutodict(t *testing.T) {
+	put := startSimPUT(t, "-autodict", "KEYWORD,0xMAGIC")
+	put.clean()
+
+	dict := buildDict(nil, false)
+	for _, tok := range []string{"KEYWORD", "0xMAGIC"} {
+		found := false
+		for _, dTok := range dict {
+			found = found || string(dTok) == tok
+		}
+		if !found {
+			t.Errorf("Token %q of the auto dictionary is missing.", tok)
+		}
+	}
+
+	hMut := makeHavocMutator([]byte("some seed input"), 1, [][]byte{
+		[]byte("KEYWORD")}, 1)
+	var usedN int
+	for i := 0; i < 1000; i++ {
+		if bytes.Contains(hMut.generate(), []byte("KEYWORD")) {
+			usedN++
+		}
+	}
+	if usedN == 0 {
+		t.Error("Dictionary never used by the havoc mutator.")
+	}
+}
+
+func TestCmpConstants(t *testing.T) {
+	code := []byte{
+		0x3d, 0x37, 0x13, 0x00, 0x00, // cmp eax, 0x1337
+		0x48, 0x81, 0xf9, 'G', 'I', 'F', '8', // cmp rcx, "GIF8"
+		0x81, 0x7d, 0xf0, 'P', 'K', 0x03, 0x04, // cmp [rbp-0x10], "PK\3\4"
+		0x66, 0x3d, 'M', 'Z', // cmp ax, "MZ"
+		0x49, 0xb8, 0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n', // movabs r8
+		0x3d, 0x00, 0x01, 0x00, 0x00, // cmp eax, 0x100: boring.
+		0x48, 0x3d, 0xf0, 0xff, 0xff, 0xff, // cmp rax, -16: boring.
+		0x83, 0xf8, 0x05, // cmp eax, 5 (imm8)
+		0x3d, 0x37, 0x13, 0x00, 0x00, // Duplicate.
+		0x3d, 0x11, // Truncated.
+	}
+	expected := [][]byte{
+		{0x37, 0x13, 0x00, 0x00},
+		[]byte("GIF8"),
+		[]byte("PK\x03\x04"),
+		[]byte("MZ"),
+		[]byte("\x89PNG\r\n\x1a\n"),
+	}
+	if consts := cmpConstants(code); !reflect.DeepEqual(consts, expected) {
+		t.Errorf("Constants are %q, expected %q.", consts, expected)
+	}
+}
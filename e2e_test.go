@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Rounds of each seed: enough for their PCA to get through its phases.
+const e2eRoundN = 2
+
+// Whole campaign (seeds, fuzzing and divergence phases, crashes and exports)
+// on the simulated fork server target. Hangs are only tested in
+// TestForkserverRun: they slow the campaign down too much. Without evolution,
+// one seed is added (one more thread than seeds), so the phases end after
+// their rounds.
+func TestFuzzLoopE2E(t *testing.T) {
+	if testing.Short() {
+		t.Skip("End-to-end test is long.")
+	}
+	outDir := filepath.Join(t.TempDir(), "out")
+	createOutDir(outDir)
+	if !startCrashCollector(outDir, nil) || !startHangCollector(outDir) {
+		t.Fatal("Couldn't start crash/hang collectors.")
+	}
+	defer crashColl.stop()
+	defer hangColl.stop()
+
+	factory := simTargetFactory(t, aflBackend, putOptions{}, "-persistent")
+	seedInputs := [][]byte{
+		[]byte("hello world, this is a seed."),
+		[]byte("another seed, with different bytes: 0123456789"),
+		[]byte("CRASH"),
+	}
+	threads, ok := startMultiThreads(len(seedInputs)+1, factory, nil,
+		calibTimeout)
+	if !ok {
+		t.Fatal("Couldn't start threads.")
+	}
+	defer func() {
+		for _, th := range threads {
+			th.clean()
+		}
+	}()
+	defer func(evo, logF, trackF, didDiv bool, power string) {
+		useEvoA, logFreq, trackGlbFreqs, didDivPhase, powerSched = evo, logF,
+			trackF, didDiv, power
+		rewardFinder = nil
+	}(useEvoA, logFreq, trackGlbFreqs, didDivPhase, powerSched)
+
+	initSeeds := execInitSeed(threads, seedInputs)
+	if len(initSeeds) != len(seedInputs) {
+		t.Fatalf("Only %d/%d seeds executed.", len(initSeeds), len(seedInputs))
+	}
+	calibrateTimeout(threads, initSeeds)
+	stab := calibrateStability(threads, initSeeds)
+	if stab.varN != 0 {
+		t.Errorf("Deterministic target has %d variable edges.", stab.varN)
+	}
+
+	evolution := false
+	plan := defaultPlan()
+	plan.Evolution = &evolution
+	plan.Phases[1].Rounds = 1
+	if !plan.normalize(e2eRoundN, powerUniform, havocStackMax) {
+		t.Fatal("Invalid plan.")
+	}
+	plan.apply()
+	res := runPlan(plan, threads, initSeeds)
+	if len(res.seeds) <= len(initSeeds) {
+		t.Errorf("No new seed found (%d seeds).", len(res.seeds))
+	} else if res.finder == nil {
+		t.Error("Divergence phase didn't run.")
+	}
+	exportResults(plan, res, stab, outDir)
+	crashColl.stop() // Saves the pending crashes.
+
+	for _, dir := range []string{"crashes", "seeds"} {
+		infos, err := ioutil.ReadDir(filepath.Join(outDir, dir))
+		if err != nil {
+			t.Errorf("Couldn't read %s directory: %v.", dir, err)
+		} else if len(infos) == 0 {
+			t.Errorf("Nothing in %s directory.", dir)
+		}
+	}
+	exports := []string{"pcas.csv", "hashes.csv", "coords.csv", "stability.csv",
+		"lineage.csv", "energy.csv", "regions.csv"}
+	for _, name := range exports {
+		if _, err := os.Stat(filepath.Join(outDir, name)); err != nil {
+			t.Errorf("Export %s missing: %v.", name, err)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"log"
 
 	"os"
@@ -13,14 +14,19 @@ import (
 // ********************************* Thread ************************************
 
 type thread struct {
-	put *aflPutT
-	cpu int
+	tgt    *managedTarget
+	cmpTgt *managedTarget // Cmplog binary, if any.
+	cpu    int
 
 	execChan chan *executor
 	endChan  chan struct{}
+	busy     sync.Mutex // Locked while executing.
 }
 
-func startThread(binPath string, cliArgs []string) (t *thread, ok bool) {
+// cmpFactory is nil if there is no cmplog binary.
+func startThread(factory, cmpFactory targetFactory, timeout time.Duration) (
+	t *thread, ok bool) {
+
 	t = &thread{
 		execChan: make(chan *executor),
 		endChan:  make(chan struct{}),
@@ -36,19 +42,28 @@ func startThread(binPath string, cliArgs []string) (t *thread, ok bool) {
 			return
 		}
 
-		t.put, ok = startAFLPUT(binPath, cliArgs, 100*time.Millisecond)
+		t.tgt, ok = startManagedTarget(factory, timeout)
+		if ok && cmpFactory != nil {
+			t.cmpTgt, ok = startManagedTarget(cmpFactory, timeout)
+			if !ok {
+				t.tgt.clean()
+			}
+		}
 		wg.Done()
 		if !ok {
+			log.Printf("Couldn't start target on CPU %d.\n", t.cpu)
 			return
 		}
 
 		_, sigChan := intChans.add() // Get notified when interrupted.
 		for e := range t.execChan {
+			t.busy.Lock()
 			if e.oneExec {
-				e.executeOne(t.put)
+				e.executeOne(t.tgt)
 			} else {
-				e.executeLoop(t.put, sigChan)
+				e.executeLoop(t.tgt, t.cmpTgt, sigChan)
 			}
+			t.busy.Unlock()
 			t.endChan <- struct{}{}
 		}
 	}()
@@ -57,24 +72,42 @@ func startThread(binPath string, cliArgs []string) (t *thread, ok bool) {
 	return t, ok
 }
 
-func (t *thread) clean() { t.put.clean() }
+// Waits for the current execution (if any) to end. The thread can't be used
+// afterwards.
+func (t *thread) clean() {
+	t.busy.Lock()
+	t.tgt.clean()
+	if t.cmpTgt != nil {
+		t.cmpTgt.clean()
+	}
+	releaseCPU(t.cpu)
+}
+
+// Only call when the thread isn't executing anything.
+func (t *thread) setTimeout(timeout time.Duration) {
+	t.tgt.timeout = timeout
+	if t.cmpTgt != nil {
+		t.cmpTgt.timeout = timeout
+	}
+}
 
-func startMultiThreads(n int, binPath string, cliArgs []string) (
-	threads []*thread, ok bool) {
+func startMultiThreads(n int, factory, cmpFactory targetFactory,
+	timeout time.Duration) (threads []*thread, ok bool) {
 
 	nbCPU := runtime.NumCPU()
 	if n > nbCPU {
-		log.Fatalf("There are only %d CPUs but you ask for %d threads.\n",
-			nbCPU, n)
+		log.Printf("Warning: there are only %d CPUs but you ask for %d threads."+
+			"\n", nbCPU, n)
 	}
 
 	for i := 0; i < n; i++ {
-		t, ok := startThread(binPath, cliArgs)
+		t, ok := startThread(factory, cmpFactory, timeout)
 		if !ok {
 			return threads, ok
 		}
 		threads = append(threads, t)
 	}
+	fmt.Printf("Target capabilities: %v.\n", threads[0].tgt.capabilities())
 	ok = true
 	return threads, ok
 }
@@ -89,29 +122,26 @@ type executor struct {
 	securityPolicy fitnessFunc
 
 	fitChan, crashChan chan<- runT
+	hangChan           chan<- runT
 	oneExec            bool
+
+	parentHash uint64 // Hash of the seed being fuzzed (for lineage).
 }
 
-func (e executor) executeOne(put *aflPutT) {
+func (e executor) executeOne(tgt *managedTarget) {
 	testCase := e.ig.generate()
+	e.execute(tgt, testCase)
+}
+func (e executor) executeLoop(tgt, cmpTgt *managedTarget,
+	sigChan chan os.Signal) {
 
-	runInfo, _ := put.run(testCase)
-
-	runInfo.trace = make([]byte, len(put.trace))
-	copy(runInfo.trace, put.trace)
-	dF := e.discoveryFit.isFit(runInfo)
-	isCrash := e.securityPolicy.isFit(runInfo)
-	//
-	if dF || isCrash {
-		if dF {
-			e.fitChan <- runInfo
-		}
-		if isCrash {
-			e.crashChan <- runInfo
+	if cu, ok := e.ig.(cmpUser); ok && cmpTgt != nil {
+		if input, needed := cu.needsCmps(); needed {
+			pairs, _ := cmpOperands(cmpTgt, input)
+			cu.setCmps(pairs) // Even if failed: don't try again.
 		}
 	}
-}
-func (e executor) executeLoop(put *aflPutT, sigChan chan os.Signal) {
+
 	timer := time.NewTimer(roundTime)
 	fuzzContinue := true
 	for fuzzContinue {
@@ -124,23 +154,48 @@ func (e executor) executeLoop(put *aflPutT, sigChan chan os.Signal) {
 			break
 
 		default:
+			if tgt.broken { // Nothing to do with this thread anymore.
+				fuzzContinue = false
+				break
+			}
 			testCase := e.ig.generate()
+			e.execute(tgt, testCase)
+		}
+	}
+}
 
-			runInfo, _ := put.run(testCase)
-
-			runInfo.trace = make([]byte, len(put.trace))
-			copy(runInfo.trace, put.trace)
-			dF := e.discoveryFit.isFit(runInfo)
-			isCrash := e.securityPolicy.isFit(runInfo)
-			//
-			if dF || isCrash {
-				if dF {
-					e.fitChan <- runInfo
-				}
-				if isCrash {
-					e.crashChan <- runInfo
-				}
-			}
+func (e executor) execute(tgt *managedTarget, testCase []byte) {
+	runInfo, ok := tgt.safeRun(testCase, tgt.timeout)
+	if ok && runInfo.hanged { // Confirm with a longer timeout (could be slow).
+		runInfo, ok = tgt.safeRun(testCase, hangConfirmMult*tgt.timeout)
+	}
+	if !ok {
+		return
+	}
+	countExec()
+
+	runInfo.parent = e.parentHash
+	if pg, ok := e.ig.(partnerGen); ok {
+		runInfo.partner = pg.partner()
+	}
+	trace := tgt.getTrace()
+	runInfo.trace = make([]byte, len(trace))
+	copy(runInfo.trace, trace)
+	if maskTrace(runInfo.trace) {
+		runInfo.hash = hashTrBits(runInfo.trace)
+	}
+	dF := e.discoveryFit.isFit(runInfo)
+	isCrash := e.securityPolicy.isFit(runInfo)
+	//
+	if dF || isCrash || runInfo.hanged {
+		if dF {
+			e.fitChan <- runInfo
+		}
+		if isCrash {
+			e.crashChan <- runInfo
+		}
+		if runInfo.hanged {
+			e.hangChan <- runInfo
 		}
 	}
 }
@@ -157,6 +157,34 @@ func exportHashes(seeds []*seedT, path string) {
 	writeCSV(w, records)
 }
 
+func exportStability(stab stabilityInfo, path string) {
+	ok, w := makeCSVFile(path)
+	if !ok {
+		return
+	}
+
+	records := [][]string{[]string{
+		"hash", "run_n", "edge_n", "var_edge_n", "stability",
+	}}
+	for _, ss := range stab.seeds {
+		records = append(records, []string{
+			fmt.Sprintf("0x%x", ss.hash),
+			fmt.Sprintf("%d", ss.runN),
+			fmt.Sprintf("%d", ss.edgeN),
+			fmt.Sprintf("%d", ss.varN),
+			fmt.Sprintf("%f", ss.stabilityPct),
+		})
+	}
+	records = append(records, []string{ // All seeds.
+		"all", "",
+		fmt.Sprintf("%d", stab.edgeN),
+		fmt.Sprintf("%d", stab.varN),
+		fmt.Sprintf("%f", stab.stabilityPct),
+	})
+
+	writeCSV(w, records)
+}
+
 // ********************************************
 // ***** Export all kind of seed distance *****
 
@@ -331,6 +359,8 @@ func (rf regionFinder) export(outDir string) {
 		return
 	}
 
+	rf.mtx.RLock()
+	defer rf.mtx.RUnlock()
 	records := [][]string{[]string{"species_n", "sample_n", "dist_avg", "dist_var"}}
 	for _, r := range rf.regions {
 		var avg, v float64
@@ -353,6 +383,55 @@ func (rf regionFinder) export(outDir string) {
 // *****************************************************************************
 // *************************** Save Seeds on Disk ******************************
 
+func exportLineage(seeds []*seedT, path string) {
+	if len(seeds) == 0 {
+		return
+	}
+	ok, w := makeCSVFile(path)
+	if !ok {
+		return
+	}
+
+	records := [][]string{[]string{"hash", "parent", "partner"}}
+	for _, seed := range seeds {
+		records = append(records, []string{
+			fmt.Sprintf("0x%x", seed.hash),
+			fmt.Sprintf("0x%x", seed.parent),
+			fmt.Sprintf("0x%x", seed.partner),
+		})
+	}
+
+	writeCSV(w, records)
+}
+
+func exportEnergy(seeds []*seedT, path string) {
+	if len(seeds) == 0 {
+		return
+	}
+	ok, w := makeCSVFile(path)
+	if !ok {
+		return
+	}
+
+	stats := getPowerStats(seeds)
+	records := [][]string{[]string{"hash", "schedule", "energy", "rounds",
+		"path_freq", "child_n", "exec_time_us"}}
+	for _, seed := range seeds {
+		energy := seedEnergy(seed, powerSched, stats)
+		records = append(records, []string{
+			fmt.Sprintf("0x%x", seed.hash),
+			powerSched,
+			fmt.Sprintf("%.4g", energy),
+			fmt.Sprintf("%d", seed.execN),
+			fmt.Sprintf("%d", pathFreqs.get(seed.hash)),
+			fmt.Sprintf("%d", seed.childN),
+			fmt.Sprintf("%d", seed.execTime.Microseconds()),
+		})
+	}
+
+	writeCSV(w, records)
+}
+
 func saveSeeds(outDir string, seeds []*seedT) {
 	dir := filepath.Join(outDir, "seeds")
 	err := os.Mkdir(dir, 0755)
@@ -371,6 +450,8 @@ func saveSeeds(outDir string, seeds []*seedT) {
 		err := ioutil.WriteFile(path, in, 0644)
 		if err != nil {
 			log.Printf("Couldn't write seed %d: %v.\n", i, err)
+			continue
 		}
+		saveLines(path+".meta", seedMeta(seed))
 	}
 }
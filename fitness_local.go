@@ -3,6 +3,9 @@ package main
 import (
 	"fmt"
 
+	"math"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"gonum.org/v1/gonum/mat"
@@ -186,6 +189,57 @@ func (pff *pcaFitFunc) String() string {
 	return pff.dynpca.String()
 }
 
+// *****************************************************************************
+// ***************************** Local Entropy *********************************
+// Online estimate of the species (trace hashes) entropy of a seed's test cases,
+// for the entropic power schedule. Unlike hashesF, independent of logFreq and
+// not saturated. Like libFuzzer Entropic, unseen species are accounted for
+// with a pseudo-count: one more species, seen once. Memory is bounded: once
+// entropyMaxSpecies species are tracked, the new ones count as seen once
+// (overestimating the entropy of the seeds with that many species).
+
+type entropyFitFunc struct {
+	freqs   map[uint64]uint32
+	sampleN int
+	fLogF   float64 // Sum of f*log(f) over the species.
+
+	entropyBits uint64 // Estimate, read by the scheduler (atomic).
+}
+
+func newEntropyFitFunc() *entropyFitFunc {
+	return &entropyFitFunc{freqs: make(map[uint64]uint32)}
+}
+
+func (eff *entropyFitFunc) isFit(runInfo runT) bool {
+	eff.sampleN++
+	if fI, ok := eff.freqs[runInfo.hash]; ok ||
+		len(eff.freqs) < entropyMaxSpecies {
+
+		f := float64(fI)
+		eff.freqs[runInfo.hash]++
+		if f > 0 {
+			eff.fLogF -= f * math.Log(f)
+		}
+		eff.fLogF += (f + 1) * math.Log(f+1)
+	} // Else, seen once: 1*log(1) is 0.
+
+	n := float64(eff.sampleN + 1)
+	entropy := math.Log(n) - eff.fLogF/n
+	atomic.StoreUint64(&eff.entropyBits, math.Float64bits(entropy))
+	return false
+}
+
+// Not ok if no test case was executed yet.
+func (eff *entropyFitFunc) entropy() (entropy float64, ok bool) {
+	bits := atomic.LoadUint64(&eff.entropyBits)
+	return math.Float64frombits(bits), bits != 0
+}
+
+func (eff *entropyFitFunc) String() string {
+	entropy, _ := eff.entropy()
+	return fmt.Sprintf("Local entropy: %.3v", entropy)
+}
+
 // *****************************************************************************
 // ************************** Divergence Fitness *******************************
 
@@ -207,6 +261,7 @@ type projectedPt struct {
 }
 type regionFinder struct {
 	regionChan chan projectedPt
+	mtx        *sync.RWMutex // Regions are read by the scheduler.
 	regions    []regionT
 
 	// Hash: seed ID
@@ -220,6 +275,7 @@ func appendDivFitFunc(seeds []*seedT, glbProj globalProjection) (
 	regionN := len(glbProj.centProjs)
 	finder = regionFinder{
 		regionChan:    make(chan projectedPt, 100),
+		mtx:           &sync.RWMutex{},
 		regions:       make([]regionT, regionN),
 		seedRegionCnt: make(map[uint64][]int),
 	}
@@ -267,9 +323,29 @@ func (divFitness) String() string { return "Divergence fitness" }
 
 func (rf regionFinder) listen() {
 	for projPt := range rf.regionChan {
+		rf.mtx.Lock()
 		closestRI := findRegion(rf.regions, projPt.proj, projPt.hash)
 		rf.seedRegionCnt[projPt.seedHash][closestRI]++
+		rf.mtx.Unlock()
+	}
+}
+
+// Expected reward of the regions the seed's test cases land in, weighted by
+// how often they do. Not ok if the seed has no sample (yet).
+func (rf regionFinder) seedReward(hash uint64) (reward float64, ok bool) {
+	rf.mtx.RLock()
+	defer rf.mtx.RUnlock()
+	var sampleN int
+	for ri, cnt := range rf.seedRegionCnt[hash] {
+		reward += float64(cnt) * rf.regions[ri].expectedSampleReward()
+		sampleN += cnt
+	}
+	if sampleN == 0 {
+		return reward, ok
 	}
+	reward /= float64(sampleN)
+	ok = true
+	return reward, ok
 }
 
 // *****************************************************************************
@@ -277,17 +353,15 @@ func (rf regionFinder) listen() {
 
 var glbFreqFitChan chan uint64
 
+// Fed by the freqFitFunc of the fitness stacks (see trackGlbFreqs).
 func init() {
-	if trackGlbFreqs {
-		glbFreqFitChan = make(chan uint64, 100000)
-		go listenGlbFreqs()
-	}
+	glbFreqFitChan = make(chan uint64, 100000)
+	go listenGlbFreqs()
 }
 
 func listenGlbFreqs() {
 	ticker := time.NewTicker(printTickT)
-	hashes := make(map[uint64]uint16)
-	freqs := map[uint16]uint16{1: 0, 2: 0}
+	freqs := map[uint32]uint32{1: 0, 2: 0}
 	var totSpecies int
 
 	var stop bool
@@ -302,23 +376,21 @@ func listenGlbFreqs() {
 			}
 			fmt.Printf("f1: %d (%.1f%%)\tf2: %d (%.1f%%).\ttot: %.2v\t"+
 				"Coverage progress estimation: %.1f%%\n", f1, f1P, f2, f2P, totS, progress)
+			if totSpecies > 0 {
+				glbProgress.add(pathProgress{time.Now(), totSpecies, progress})
+			}
 
 		case hash, okChan := <-glbFreqFitChan:
 			if !okChan {
 				stop = true
 				break
 			}
-			//
-			if freq, ok := hashes[hash]; !ok {
-				hashes[hash] = 1
+			// Also used by power schedules.
+			if freq := pathFreqs.inc(hash) - 1; freq == 0 {
 				freqs[1]++
 				totSpecies++
 			} else {
-				hashes[hash] = freq + 1
 				freqs[freq]--
-				if _, ok := freqs[freq]; !ok {
-					freqs[freq] = 0
-				}
 				freqs[freq+1]++
 			}
 		}
@@ -9,10 +9,29 @@ const (
 	// ** Scheduling **
 	roundTime      = 5 * time.Second
 	fuzzRoundNBase = 5
+	// Power schedules: bounds of the energy (1 is a uniform schedule).
+	powerMaxFactor = 32
+	powerMinEnergy = 1.0 / 1024
+	// Entropic: species (trace hashes) whose frequency each seed tracks.
+	entropyMaxSpecies = 1 << 12
+	// Budget: how often it's checked, and window of the discovery rate.
+	budgetCheckT     = time.Second
+	budgetRateWindow = time.Minute
 
 	// **********************
 	// ** Input Generation **
 	mutationRatio = 1.0 / 100
+	// Havoc (values from AFL).
+	havocStackMax    = 128 // Default maximum number of stacked mutations.
+	havocArithMax    = 35
+	havocMaxLen      = 1 << 20
+	havocBlockSmall  = 32
+	havocBlockMedium = 128
+	havocBlockLarge  = 1500
+	havocBlockXL     = 32768
+	// Splicing.
+	spliceRatio = 0.2 // Part of the test cases that are spliced.
+	spliceCandN = 8   // Candidates considered to find a far partner.
 
 	// *****************************************
 	// ** pcaFitFunc initialization constants **
@@ -32,6 +51,30 @@ const (
 
 	bucketSensitiveness = 10 // How many buckets per std in histogram.
 
+	// ***********
+	// ** Hangs **
+	// Timeout used until the seeds were executed (if not given by the user).
+	calibTimeout = time.Second
+	// Timeout is the slowest seed execution times this; within min and max.
+	hangTimeoutMult = 5
+	hangTimeoutMin  = 20 * time.Millisecond
+	hangTimeoutMax  = time.Second
+	// Hang confirmation: re-run with a longer timeout.
+	hangConfirmMult = 4
+
+	// ***************
+	// ** Stability **
+	// Number of executions of each seed to find variable edges.
+	stabilityRunN = 8
+
+	// ************
+	// ** Crashes **
+	// If true, a crash is unique only if it triggers a branch no previous crash
+	// triggered (like AFL). Otherwise, signal and trace hash are enough.
+	crashDedupByEdge = true
+	// Capture mode: timeout of the (standalone) crash re-execution.
+	captureTimeout = 5 * time.Second
+
 	// *************
 	// ** Verbose **
 	printTickT = 3 * time.Second
@@ -40,27 +83,18 @@ const (
 	// ** Distance parameter **
 	regulizer = 0.1
 
-	// ************
-	// ** System **
-	deactivateHyperthread = true
-
 	// *****************
 	// ** Experiments **
-	useEvoA = true  // Turn evolutionnary algorithm off for experiment.
-	logFreq = false // Log hash frequencies for MLE divergence estimation.
-	//
-	// Based on all the seeds and their PCA, get a global base. Record
-	// historgram on this base and compute divergences.
-	doDivPhase = true
-	//
-	trackGlbFreqs = true
+	// Rounds per seed by default when logging hash frequencies.
+	fuzzRoundNLogFreq = 12
 )
 
-var fuzzRoundN = fuzzRoundNBase
-var didDivPhase bool
+// Experiment switches, set by the campaign plan (see plan.go).
+var (
+	useEvoA       = true  // Turn evolutionnary algorithm off for experiment.
+	logFreq       = false // Log hash frequencies for MLE divergence estimation.
+	trackGlbFreqs = true  // Some fitness stack has the global frequencies.
+)
 
-func init() {
-	if logFreq {
-		fuzzRoundN = 12
-	}
-}
+var fuzzRoundN = fuzzRoundNBase // Default (-rounds).
+var didDivPhase bool
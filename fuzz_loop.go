@@ -2,40 +2,85 @@ package main
 
 import (
 	"fmt"
+	"log"
 
 	"math/rand"
 	"os"
 	"os/signal"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 func execInitSeed(threads []*thread, seedInputs [][]byte) (initSeeds []*seedT) {
-	initSeeds = make([]*seedT, len(seedInputs))
 	fitChan := make(chan runT, 1)
 	t := threads[0] // @TODO: For speed, should use all threads, not just one.
 
 	for i, input := range seedInputs {
-		e := &executor{
-			ig:             seedCopier(input),
-			discoveryFit:   trueFitFunc{},
-			securityPolicy: falseFitFunc{},
-			fitChan:        fitChan,
-			crashChan:      devNullFitChan,
-			oneExec:        true,
+		runInfo, ok := execOnce(t, input, fitChan)
+		if !ok {
+			log.Printf("Couldn't execute seed %d.\n", i)
+			continue
 		}
-
-		t.execChan <- e
-		<-t.endChan
-		runInfo := <-fitChan
-		initSeeds[i] = &seedT{runT: runInfo}
+		initSeeds = append(initSeeds, &seedT{runT: runInfo})
 	}
 
 	return initSeeds
 }
 
-func fuzzLoop(threads []*thread, initSeeds []*seedT) (seeds []*seedT) {
+// Execute the input once on the thread (which must be idle). fitChan needs a
+// buffer of at least one.
+func execOnce(t *thread, input []byte, fitChan chan runT) (runInfo runT, ok bool) {
+	e := &executor{
+		ig:             seedCopier(input),
+		discoveryFit:   trueFitFunc{},
+		securityPolicy: crashFitFunc{},
+		fitChan:        fitChan,
+		crashChan:      crashColl.channel(),
+		hangChan:       hangColl.channel(),
+		oneExec:        true,
+	}
+
+	t.execChan <- e
+	<-t.endChan
+	select {
+	case runInfo = <-fitChan:
+		ok = true
+	default: // The PUT couldn't run it (even after restart).
+	}
+	return runInfo, ok
+}
+
+// Set the timeout of all threads based on how long the seeds took to execute.
+func calibrateTimeout(threads []*thread, initSeeds []*seedT) time.Duration {
+	var slowest time.Duration
+	for _, seed := range initSeeds {
+		if seed.hanged {
+			continue
+		} else if seed.execTime > slowest {
+			slowest = seed.execTime
+		}
+	}
+
+	timeout := hangTimeoutMult * slowest
+	if timeout < hangTimeoutMin {
+		timeout = hangTimeoutMin
+	} else if timeout > hangTimeoutMax {
+		timeout = hangTimeoutMax
+	}
+	fmt.Printf("Slowest seed: %v.\tTimeout set to: %v.\n", slowest, timeout)
+
+	for _, t := range threads {
+		t.setTimeout(timeout)
+	}
+	return timeout
+}
+
+func fuzzLoop(threads []*thread, initSeeds []*seedT, phase phasePlan) (
+	seeds []*seedT) {
+
 	fitChan := make(chan runT, 1000)
-	sched := newScheduler(threads, initSeeds, fitChan)
+	sched := newScheduler(threads, initSeeds, fitChan, phase)
 	stopChan := makeGlbFitness(fitChan, sched.newSeedChan, initSeeds, len(threads))
 
 	seeds = <-sched.seedsChan
@@ -47,25 +92,30 @@ func fuzzLoop(threads []*thread, initSeeds []*seedT) (seeds []*seedT) {
 // ******************************** Interrupt **********************************
 
 var (
-	intChans       = newIntMulti()
-	wasInterrupted bool
+	intChans   = newIntMulti()
+	interruptN int32 // Interrupts and budget stops (atomic).
 )
 
+func wasInterrupted() bool { return atomic.LoadInt32(&interruptN) > 0 }
+
 func init() {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt)
 	go func() {
 		for s := range sigChan {
 			fmt.Printf("Signal: %+v\n", s)
-			wasInterrupted = true
+			atomic.AddInt32(&interruptN, 1)
 			intChans.signal(s)
 		}
 	}()
 }
 
+// The interrupt is latched: the loops which start after it (e.g. of a phase
+// starting when the budget ran out) are notified too.
 type interruptMultiplexer struct {
 	mtx   sync.Mutex
 	chans map[int]chan os.Signal
+	sig   os.Signal // Last signal, if any.
 }
 
 func newIntMulti() *interruptMultiplexer {
@@ -81,6 +131,9 @@ func (intChans *interruptMultiplexer) add() (
 	key = rand.Int()
 	intChans.mtx.Lock()
 	intChans.chans[key] = sigChan
+	if intChans.sig != nil {
+		sigChan <- intChans.sig
+	}
 	intChans.mtx.Unlock()
 	return key, sigChan
 }
@@ -92,6 +145,7 @@ func (intChans *interruptMultiplexer) del(key int) {
 
 func (intChans *interruptMultiplexer) signal(s os.Signal) {
 	intChans.mtx.Lock()
+	intChans.sig = s
 	for _, c := range intChans.chans {
 		if len(c) > 0 { // This channel was already signaled.
 			continue
@@ -17,8 +17,7 @@ func rol(x uint64, shift uint) uint64 {
 
 func hashTrBits(traceBits []byte) (hash uint64) {
 	const (
-		hashSeed      = 0xa5b35705 // Nothing to do with fuzzing seeds...
-		mapSize64 int = mapSize >> 3
+		hashSeed = 0xa5b35705 // Nothing to do with fuzzing seeds...
 
 		loopMult1  uint64 = 0x87c37b91114253d5
 		loopMult2  uint64 = 0x4cf5ad432745937f
@@ -33,14 +32,13 @@ func hashTrBits(traceBits []byte) (hash uint64) {
 		uint64Size        = 8
 	)
 
-	//data := (*[mapSize64]uint64)(unsafe.Pointer(traceBitPt))
 	// Unsafe but fast conversion. @TODO: maybe we could do that only once.
 	header := *(*reflect.SliceHeader)(unsafe.Pointer(&traceBits))
 	header.Len /= uint64Size
 	header.Cap /= uint64Size
 	data := *(*[]uint64)(unsafe.Pointer(&header))
 
-	hash = hashSeed ^ mapSize // ??
+	hash = hashSeed ^ uint64(len(traceBits)) // ??
 
 	for i := range data {
 		k := data[i]
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"encoding/binary"
+	"math/bits"
 	"math/rand"
 )
 
@@ -8,11 +10,77 @@ type inputGen interface {
 	generate() (testCase []byte)
 }
 
+// Input generators combining the seed with another one (partner).
+type partnerGen interface {
+	partner() uint64 // Hash of the partner of the last test case (0 if none).
+}
+
+// Input generators using the comparison operands (cmplog) of their seed: the
+// thread runs its cmplog binary on the input if needed.
+type cmpUser interface {
+	needsCmps() (input []byte, ok bool)
+	setCmps(pairs []cmpPair)
+}
+
 var (
-	_ inputGen = seedCopier([]byte{})
-	_ inputGen = ratioMutator{}
+	_ inputGen   = seedCopier([]byte{})
+	_ inputGen   = ratioMutator{}
+	_ inputGen   = havocMutator{}
+	_ inputGen   = &spliceMutator{}
+	_ partnerGen = &spliceMutator{}
+	_ inputGen   = &i2sMutator{}
+	_ cmpUser    = &i2sMutator{}
+	_ partnerGen = &i2sMutator{}
+)
+
+const (
+	ratioMutKind = "ratio"
+	havocMutKind = "havoc"
+	mixedMutKind = "mixed" // Ratio or havoc, drawn for each seed.
 )
 
+type mutatorOptions struct {
+	kind     string
+	stackMax int      // Havoc: maximum number of stacked mutations.
+	dict     [][]byte // Havoc: tokens to insert/overwrite with.
+	splice   string   // Partner policy, splicing disabled if empty.
+}
+
+var mutConf = mutatorOptions{kind: ratioMutKind, stackMax: havocStackMax}
+
+func validMutKind(kind string) bool {
+	return kind == ratioMutKind || kind == havocMutKind || kind == mixedMutKind
+}
+
+// Mutator of a seed: with the mixed mutator, one of the others drawn from its
+// RNG seed (so it is the same when the campaign is replayed).
+func seedMutKind(kind string, rngSeed int64) string {
+	if kind != mixedMutKind {
+		return kind
+	} else if deriveSeed(rngSeed, 3)&1 == 0 {
+		return ratioMutKind
+	}
+	return havocMutKind
+}
+
+// Input generator to fuzz a seed with (see -mutator and -splice). Its test
+// cases only depend on rngSeed (and splicing partners).
+func buildMutator(seedIn []byte, seedHash uint64, rngSeed int64,
+	opts mutatorOptions) (ig inputGen) {
+
+	switch opts.kind {
+	case havocMutKind:
+		ig = makeHavocMutator(seedIn, opts.stackMax, opts.dict, rngSeed)
+	default:
+		ig = makeRatioMutator(seedIn, mutationRatio, rngSeed)
+	}
+	if len(opts.splice) > 0 {
+		ig = newSpliceMutator(seedIn, seedHash, ig, opts,
+			deriveSeed(rngSeed, 1))
+	}
+	return ig
+}
+
 // *****************************************************************************
 // ******************************** Seed Gen ***********************************
 // Just a dummy input generator: always return the seed as is.
@@ -30,9 +98,9 @@ type ratioMutator struct {
 	seedIn []byte
 }
 
-func makeRatioMutator(seedIn []byte, ratio float64) ratioMutator {
+func makeRatioMutator(seedIn []byte, ratio float64, rngSeed int64) ratioMutator {
 	return ratioMutator{
-		r:      rand.New(rand.NewSource(rand.Int63())),
+		r:      rand.New(rand.NewSource(rngSeed)),
 		ratio:  ratio,
 		seedIn: seedIn,
 	}
@@ -71,3 +139,230 @@ func (rMut ratioMutator) generate() (testCase []byte) {
 
 	return testCase
 }
+
+// *****************************************************************************
+// **************************** Havoc Mutator **********************************
+// Like AFL havoc stage: a random number (power of two, up to stackMax) of
+// random mutations stacked on the seed. Some change the input length. With a
+// dictionary, tokens are also inserted or written over the input.
+
+var (
+	interesting8  = []int32{-128, -1, 0, 1, 16, 32, 64, 100, 127}
+	interesting16 = append(interesting8, []int32{
+		-32768, -129, 128, 255, 256, 512, 1000, 1024, 4096, 32767}...)
+	interesting32 = append(interesting16, []int32{
+		-2147483648, -100663046, -32769, 32768, 65535, 65536, 100663045,
+		2147483647}...)
+)
+
+const (
+	havocFlipBit = iota
+	havocInteresting8
+	havocInteresting16
+	havocInteresting32
+	havocArith8
+	havocArith16
+	havocArith32
+	havocRandByte
+	havocDelete
+	havocInsert
+	havocOverwrite
+	havocDictOverwrite // Dictionary operators last: only if there is one.
+	havocDictInsert
+	havocOpN
+)
+
+type havocMutator struct {
+	r        *rand.Rand
+	seedIn   []byte
+	stackMax int
+	dict     [][]byte
+}
+
+func makeHavocMutator(seedIn []byte, stackMax int, dict [][]byte,
+	rngSeed int64) havocMutator {
+
+	return havocMutator{
+		r:        rand.New(rand.NewSource(rngSeed)),
+		seedIn:   seedIn,
+		stackMax: stackMax,
+		dict:     dict,
+	}
+}
+
+func (hMut havocMutator) generate() (testCase []byte) {
+	testCase = make([]byte, len(hMut.seedIn))
+	copy(testCase, hMut.seedIn)
+	return hMut.stack(testCase)
+}
+
+// Stack mutations on the test case (modified in place if possible).
+func (hMut havocMutator) stack(testCase []byte) []byte {
+	stackN := 1
+	if pow := bits.Len(uint(hMut.stackMax)) - 1; pow > 0 {
+		stackN = 1 << uint(1+hMut.r.Intn(pow))
+	}
+	for i := 0; i < stackN; i++ {
+		testCase = hMut.mutate(testCase)
+	}
+	return testCase
+}
+
+func (hMut havocMutator) mutate(tc []byte) []byte {
+	r := hMut.r
+	if len(tc) == 0 { // Only inserting makes sense.
+		return hMut.insert(tc, nil)
+	}
+
+	opN := havocOpN
+	if len(hMut.dict) == 0 {
+		opN = havocDictOverwrite
+	}
+	switch op := r.Intn(opN); op {
+	case havocFlipBit:
+		tc[r.Intn(len(tc))] ^= 1 << uint(r.Intn(8))
+
+	case havocInteresting8:
+		tc[r.Intn(len(tc))] = byte(interesting8[r.Intn(len(interesting8))])
+
+	case havocInteresting16, havocArith16:
+		if len(tc) < 2 {
+			break
+		}
+		pos := r.Intn(len(tc) - 1)
+		order := hMut.byteOrder()
+		v := order.Uint16(tc[pos:])
+		if op == havocInteresting16 {
+			v = uint16(interesting16[r.Intn(len(interesting16))])
+		} else {
+			v += uint16(hMut.arith())
+		}
+		order.PutUint16(tc[pos:], v)
+
+	case havocInteresting32, havocArith32:
+		if len(tc) < 4 {
+			break
+		}
+		pos := r.Intn(len(tc) - 3)
+		order := hMut.byteOrder()
+		v := order.Uint32(tc[pos:])
+		if op == havocInteresting32 {
+			v = uint32(interesting32[r.Intn(len(interesting32))])
+		} else {
+			v += uint32(hMut.arith())
+		}
+		order.PutUint32(tc[pos:], v)
+
+	case havocArith8:
+		tc[r.Intn(len(tc))] += byte(hMut.arith())
+
+	case havocRandByte: // XOR with 1-255, so it does change.
+		tc[r.Intn(len(tc))] ^= byte(1 + r.Intn(0xff))
+
+	case havocDelete:
+		if len(tc) < 2 {
+			break
+		}
+		delLen := hMut.blockLen(len(tc) - 1)
+		pos := r.Intn(len(tc) - delLen + 1)
+		tc = append(tc[:pos], tc[pos+delLen:]...)
+
+	case havocInsert:
+		tc = hMut.insert(tc, nil)
+
+	case havocOverwrite:
+		cpLen := hMut.blockLen(len(tc))
+		dst := r.Intn(len(tc) - cpLen + 1)
+		if r.Intn(4) != 0 { // Copy of another block.
+			src := r.Intn(len(tc) - cpLen + 1)
+			copy(tc[dst:dst+cpLen], tc[src:src+cpLen])
+		} else { // Constant block.
+			b := byte(r.Intn(0x100))
+			for i := dst; i < dst+cpLen; i++ {
+				tc[i] = b
+			}
+		}
+
+	case havocDictOverwrite:
+		tok := hMut.dict[r.Intn(len(hMut.dict))]
+		if len(tok) > len(tc) {
+			break
+		}
+		copy(tc[r.Intn(len(tc)-len(tok)+1):], tok)
+
+	case havocDictInsert:
+		tc = hMut.insert(tc, hMut.dict[r.Intn(len(hMut.dict))])
+	}
+	return tc
+}
+
+// Insert the given block or, if nil, a clone of a block (3/4 of the time) or a
+// constant block.
+func (hMut havocMutator) insert(tc, block []byte) []byte {
+	r := hMut.r
+	if len(tc) >= havocMaxLen {
+		return tc
+	}
+	if block == nil && len(tc) > 0 && r.Intn(4) != 0 {
+		cloneLen := hMut.blockLen(len(tc))
+		src := r.Intn(len(tc) - cloneLen + 1)
+		block = make([]byte, cloneLen)
+		copy(block, tc[src:])
+	} else if block == nil {
+		block = make([]byte, hMut.blockLen(havocBlockXL))
+		b := byte(r.Intn(0x100))
+		for i := range block {
+			block[i] = b
+		}
+	}
+	if len(tc)+len(block) > havocMaxLen {
+		block = block[:havocMaxLen-len(tc)]
+	}
+
+	pos := r.Intn(len(tc) + 1)
+	newTC := make([]byte, 0, len(tc)+len(block))
+	newTC = append(newTC, tc[:pos]...)
+	newTC = append(newTC, block...)
+	newTC = append(newTC, tc[pos:]...)
+	return newTC
+}
+
+// Mostly small blocks, sometimes large ones (like AFL choose_block_len).
+func (hMut havocMutator) blockLen(limit int) int {
+	r := hMut.r
+	var min, max int
+	switch r.Intn(8) {
+	case 0, 1, 2, 3:
+		min, max = 1, havocBlockSmall
+	case 4, 5, 6:
+		min, max = havocBlockSmall, havocBlockMedium
+	default:
+		if r.Intn(10) != 0 {
+			min, max = havocBlockMedium, havocBlockLarge
+		} else {
+			min, max = havocBlockLarge, havocBlockXL
+		}
+	}
+	if min > limit {
+		min = 1
+	}
+	if max > limit {
+		max = limit
+	}
+	return min + r.Intn(max-min+1)
+}
+
+func (hMut havocMutator) arith() int32 {
+	v := int32(1 + hMut.r.Intn(havocArithMax))
+	if hMut.r.Intn(2) == 0 {
+		v = -v
+	}
+	return v
+}
+
+func (hMut havocMutator) byteOrder() binary.ByteOrder {
+	if hMut.r.Intn(2) == 0 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestHavocMutator(t *testing.T) {
+	seedIn := []byte("The quick brown fox jumps over the lazy dog.")
+	seedCopy := append([]byte{}, seedIn...)
+	hMut := makeHavocMutator(seedIn, havocStackMax, nil, 1)
+
+	var changedN, resizedN int
+	for i := 0; i < 1000; i++ {
+		testCase := hMut.generate()
+		if len(testCase) > havocMaxLen {
+			t.Fatalf("Test case is too long: %d bytes.", len(testCase))
+		}
+		if !bytes.Equal(testCase, seedIn) {
+			changedN++
+		}
+		if len(testCase) != len(seedIn) {
+			resizedN++
+		}
+	}
+	if !bytes.Equal(seedIn, seedCopy) {
+		t.Fatal("Seed was modified.")
+	}
+	if changedN < 900 || resizedN == 0 {
+		t.Errorf("Only %d test cases changed and %d resized out of 1000.",
+			changedN, resizedN)
+	}
+
+	// Stacking at most one mutation on an empty input: it can only grow.
+	empty := makeHavocMutator(nil, 1, nil, 1)
+	for i := 0; i < 100; i++ {
+		if testCase := empty.generate(); len(testCase) == 0 {
+			t.Fatal("Empty input wasn't mutated.")
+		}
+	}
+}
+
+// The mixed mutator draws the kind from the seed's RNG seed.
+func TestSeedMutKind(t *testing.T) {
+	counts := make(map[string]int)
+	for i := int64(0); i < 100; i++ {
+		kind := seedMutKind(mixedMutKind, i)
+		if kind != seedMutKind(mixedMutKind, i) {
+			t.Fatalf("RNG seed %d: mutator kind isn't deterministic.", i)
+		}
+		counts[kind]++
+	}
+	if counts[ratioMutKind] == 0 || counts[havocMutKind] == 0 ||
+		len(counts) != 2 {
+		t.Errorf("Mixed mutator kinds: %v.", counts)
+	}
+	if kind := seedMutKind(havocMutKind, 1); kind != havocMutKind {
+		t.Errorf("Havoc mutator became %s.", kind)
+	}
+}
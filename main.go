@@ -6,65 +6,117 @@ import (
 
 	"flag"
 	"io/ioutil"
-	"math/rand"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 )
 
-func init() {
-	randSeed := time.Now().UTC().UnixNano()
-	rand.Seed(randSeed)
-}
-
 var (
 	workDir = "/tmp" // @TODO: make it a user option
+	startT  = time.Now()
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == putExecCmd {
+		putExec(os.Args[2:])
+		return
+	} else if len(os.Args) > 1 && os.Args[1] == simTargetCmd {
+		simTarget(os.Args[2:])
+		return
+	} else if len(os.Args) > 1 && os.Args[1] == tminCmd {
+		tmin(os.Args[2:])
+		return
+	} else if len(os.Args) > 1 && os.Args[1] == cminCmd {
+		cmin(os.Args[2:])
+		return
+	} else if len(os.Args) > 1 && os.Args[1] == replayCmd {
+		replay(os.Args[2:])
+		return
+	}
+
 	fmt.Println("Hemipt start.")
 	config := parseCLI()
+	setCampaignSeed(config.randSeed)
+
+	putArgs := strings.Split(config.cliStr, " ")
+	binPath, cliArgs := putArgs[0], putArgs[1:]
+
+	factory, ok := makeTargetFactory(config.backend, binPath, cliArgs,
+		config.putOpts)
+	if !ok {
+		log.Fatal("Couldn't setup the target.")
+	}
+
+	var capture *standaloneRunner
+	if config.capture {
+		if config.backend == goBackend {
+			log.Fatal("Capture mode needs a binary target.")
+		}
+		capture, ok = newStandaloneRunner(binPath, cliArgs, config.putOpts)
+		if !ok {
+			log.Fatal("Couldn't setup the capture mode.")
+		}
+		defer capture.clean()
+	}
+	if !startCrashCollector(config.outDir, capture) {
+		log.Println("Crashes won't be saved.")
+	}
+	if !startHangCollector(config.outDir) {
+		log.Println("Hangs won't be saved.")
+	}
+
+	var userDict [][]byte
+	if len(config.dictPath) > 0 {
+		userDict, ok = loadDict(config.dictPath)
+		if !ok {
+			log.Fatal("Couldn't load the dictionary.")
+		}
+	}
 
 	seedInputs := readSeeds(config.inDir)
 	if len(seedInputs) == 0 {
 		log.Fatal("No seed given")
 	}
 
-	putArgs := strings.Split(config.cliStr, " ")
-	binPath, cliArgs := putArgs[0], putArgs[1:]
-	threads, ok := startMultiThreads(config.threadN, binPath, cliArgs)
+	timeout := config.putOpts.timeout
+	if timeout == 0 {
+		timeout = calibTimeout
+	}
+	var cmpFactory targetFactory // Cmplog binary gets the same CLI.
+	if len(config.cmplogPath) > 0 {
+		cmpOpts := config.putOpts
+		cmpOpts.cmplog = true
+		cmpFactory, _ = makeTargetFactory(aflBackend, config.cmplogPath,
+			cliArgs, cmpOpts)
+	}
+	cpuConf, mutConf, budgetConf = config.cpuOpts, config.mutOpts,
+		config.budget
+	config.plan.apply()
+	config.plan.save(filepath.Join(config.outDir, "plan.json"))
+	threads, ok := startMultiThreads(config.threadN, factory, cmpFactory,
+		timeout)
 	if !ok {
 		log.Print("Problem starting thread.")
 		return
 	}
+	mutConf.dict = buildDict(userDict, config.noAutoDict)
+	saveDict(config.outDir, mutConf.dict)
 
 	//seedExecTest(threads, seedInputs) // Old test
 
+	watchBudget(budgetConf)
 	initSeeds := execInitSeed(threads, seedInputs)
-	seeds := fuzzLoop(threads, initSeeds)
-	//
-	if doDivPhase && !wasInterrupted {
-		fmt.Println("")
-		ok, glbProj := doGlbProjection(seeds)
-		if !ok {
-			log.Println("Couldn't get global basis. Divergence phase aborted.")
-		} else {
-			okDFF, finder := appendDivFitFunc(seeds, glbProj)
-			if okDFF {
-				fmt.Println("")
-				seeds = fuzzLoop(threads, seeds)
-				didDivPhase = true
-				finder.export(config.outDir)
-			}
-		}
+	if len(initSeeds) == 0 {
+		log.Fatal("No seed could be executed.")
 	}
-	// ** Epilogue **
-	if didDivPhase {
-		checkHistos(seeds)
+	if config.putOpts.timeout == 0 {
+		calibrateTimeout(threads, initSeeds)
 	}
-	export(config.outDir, seeds)
-	saveSeeds(config.outDir, seeds)
+	stab := calibrateStability(threads, initSeeds)
+	res := runPlan(config.plan, threads, initSeeds)
+	// ** Epilogue **
+	exportResults(config.plan, res, stab, config.outDir)
 
 	for _, t := range threads {
 		t.clean()
@@ -94,11 +146,24 @@ func export(outDir string, seeds []*seedT) {
 
 type configOptions struct {
 	// PUT interface
-	cliStr string
+	backend    string
+	cliStr     string     // Go harness name for the Go backend.
+	putOpts    putOptions // If timeout is 0, calibrated on seeds.
+	cmplogPath string     // AFL++ cmplog binary of the PUT, if any.
 
 	// Fuzzer configuration
 	inDir, outDir string
 	threadN       int
+	cpuOpts       cpuOptions
+	mutOpts       mutatorOptions
+	powerSched    string
+	budget        budgetOptions
+	rounds        int          // Per seed; 0: fuzzRoundN.
+	plan          campaignPlan // Phases and exports.
+	dictPath      string
+	noAutoDict    bool  // Tokens from the binary and AFL++ auto dictionary.
+	capture       bool  // Re-execute crashes to get their output and report.
+	randSeed      int64 // 0: from the clock.
 }
 
 func parseCLI() (config configOptions) {
@@ -106,8 +171,90 @@ func parseCLI() (config configOptions) {
 	flag.StringVar(&config.inDir, "i", "", "Seed directory")
 	flag.StringVar(&config.outDir, "o", "", "Output directory")
 	flag.IntVar(&config.threadN, "n", 2, "Number of threads Hemipt uses")
+	cpuList := flag.String("cpus", "",
+		"CPUs to pin threads to, e.g. 0-3,8 (default: from the CPU topology)")
+	flag.BoolVar(&config.cpuOpts.noPin, "nopin", false,
+		"Don't pin threads to CPUs (e.g. in containers)")
+	flag.StringVar(&config.mutOpts.kind, "mutator", ratioMutKind, fmt.Sprintf(
+		"Mutator fuzzing the seeds: %s (bit flips at a fixed ratio), %s "+
+			"(AFL-like stacked mutations) or %s (either, drawn for each seed)",
+		ratioMutKind, havocMutKind, mixedMutKind))
+	flag.IntVar(&config.mutOpts.stackMax, "stack", havocStackMax,
+		"Maximum number of stacked havoc mutations")
+	flag.StringVar(&config.mutOpts.splice, "splice", "", fmt.Sprintf(
+		"Also splice seeds with a partner chosen at %s, %s (in the global "+
+			"projection) or in another %s (default: no splicing)",
+		spliceRandom, spliceFar, spliceRegion))
+	flag.StringVar(&config.powerSched, "power", powerUniform, fmt.Sprintf(
+		"Power schedule, how seeds share the fuzzing rounds: %s, %s, %s, %s, "+
+			"%s (like AFLFast), %s (local species entropy, like libFuzzer) or "+
+			"%s (expected reward of the regions of the divergence phase)",
+		powerUniform, powerExplore, powerFast, powerCOE, powerExploit,
+		powerEntropic, powerRegion))
+	flag.DurationVar(&config.budget.maxTime, "time", 0,
+		"Stop the campaign after this time, e.g. 24h (default: no limit). "+
+			"Without -rounds, the last phase fuzzes until then")
+	flag.Uint64Var(&config.budget.maxExecN, "execs", 0,
+		"Stop the campaign after this many executions (default: no limit). "+
+			"Without -rounds, the last phase fuzzes until then")
+	flag.IntVar(&config.rounds, "rounds", 0, fmt.Sprintf(
+		"Fuzzing rounds (of %v) per seed and phase (default: %d). With a "+
+			"budget (-time, -execs, -completeness or -minrate), the last "+
+			"phase runs until it is exhausted unless -rounds is given; "+
+			"otherwise the first limit reached stops the campaign",
+		roundTime, fuzzRoundN))
+	planPath := flag.String("plan", "", "Campaign plan (JSON): phases, "+
+		"their fitness functions, mutator, power schedule and rounds, and "+
+		"exports (default: fuzzing then divergence phase, all exports)")
+	flag.Float64Var(&config.budget.completeness, "completeness", 0,
+		"Stop once the estimated coverage completeness (f1/f2 path "+
+			"frequencies) exceeds this percentage (default: never)")
+	flag.Float64Var(&config.budget.minRate, "minrate", 0, fmt.Sprintf(
+		"Stop once fewer new paths per minute are found (over the last %v; "+
+			"default: never)", budgetRateWindow))
+	flag.StringVar(&config.dictPath, "x", "",
+		"Dictionary file (AFL/libFuzzer format) or directory of tokens, for"+
+			" the havoc mutator and splicing")
+	flag.BoolVar(&config.noAutoDict, "noautodict", false, "Don't add the"+
+		" printable strings and compared constants of the binary nor the"+
+		" AFL++ auto dictionary to the dictionary")
+	timeoutMs := flag.Int("t", 0,
+		"Timeout of a PUT execution in ms (default: calibrated on seeds)")
+	flag.BoolVar(&config.putOpts.noShmFuzz, "noshmfuzz", false,
+		"Don't deliver test cases through shared memory, even if the PUT can")
+	flag.BoolVar(&config.capture, "capture", false,
+		"Re-execute crashes outside the fork server to save their output and"+
+			" sanitizer report (also used for deduplication)")
+	flag.Int64Var(&config.randSeed, "seed", 0,
+		"Seed of the random number generators, to reproduce a campaign "+
+			"(default: from the clock)")
+	flag.StringVar(&config.backend, "backend", aflBackend, fmt.Sprintf(
+		"Execution backend: %s (fork server), %s (non-instrumented) or %s "+
+			"(in-process harness, given by -cli)",
+		aflBackend, dumbBackend, goBackend))
+	dumb := flag.Bool("dumb", false, "Same as -backend "+dumbBackend)
+	flag.StringVar(&config.cmplogPath, "cmplog", "",
+		"AFL++ cmplog binary of the PUT, for input-to-state mutations")
+	flag.Uint64Var(&config.putOpts.memLimit, "m", 0,
+		"Memory limit (address space) of the PUT in MB (0: none)")
+	flag.Uint64Var(&config.putOpts.fsizeLimit, "fsize", 0,
+		"Limit of the size of the files the PUT creates in MB (0: none)")
+	flag.BoolVar(&config.putOpts.sandbox, "sandbox", false,
+		"Run the PUT in new user/mount/network namespaces with a private tmpfs"+
+			" working directory")
 
 	flag.Parse()
+	config.putOpts.timeout = time.Duration(*timeoutMs) * time.Millisecond
+	if *dumb {
+		config.backend = dumbBackend
+	}
+	if len(*cpuList) > 0 {
+		var okList bool
+		config.cpuOpts.list, okList = parseCPUList(*cpuList)
+		if !okList {
+			log.Fatal("Invalid -cpus list.")
+		}
+	}
 
 	if len(config.cliStr) == 0 {
 		flag.Usage()
@@ -118,6 +265,51 @@ func parseCLI() (config configOptions) {
 	} else if len(config.outDir) == 0 {
 		flag.Usage()
 		log.Fatal("Please provide an output directory.")
+	} else if len(config.cmplogPath) > 0 && config.backend != aflBackend {
+		log.Fatal("Cmplog binary needs the AFL backend.")
+	} else if !validMutKind(config.mutOpts.kind) {
+		log.Fatalf("Unknown mutator: %s.\n", config.mutOpts.kind)
+	} else if config.mutOpts.stackMax < 1 {
+		log.Fatal("Havoc stack must be at least 1.")
+	}
+	if config.rounds < 0 {
+		log.Fatal("Negative number of rounds.")
+	}
+	config.plan = defaultPlan()
+	if len(*planPath) > 0 {
+		var okPlan bool
+		config.plan, okPlan = loadPlan(*planPath)
+		if !okPlan {
+			log.Fatal("Couldn't load the campaign plan.")
+		}
+	}
+	if config.rounds == 0 && config.budget.bounded() {
+		config.plan.unboundLastPhase()
+	}
+	if !config.plan.normalize(config.rounds, config.powerSched,
+		config.mutOpts.stackMax) {
+		log.Fatal("Invalid campaign plan.")
+	} else if config.budget.adaptive() && !config.plan.tracksFreqs() {
+		log.Fatal("Adaptive stopping needs the global path frequencies " +
+			"(freq fitness function).")
+	}
+	// Only havoc (also splicing, after the crossover) uses the dictionary.
+	usesDict := len(config.mutOpts.splice) > 0 ||
+		config.plan.usesHavoc(config.mutOpts.kind)
+	if len(config.dictPath) > 0 && !usesDict {
+		log.Fatal("The dictionary (-x) is only used by the havoc or mixed " +
+			"mutator, or by splicing.")
+	} else if !usesDict {
+		config.noAutoDict = true
+	}
+	if !validPowerSched(config.powerSched) {
+		log.Fatalf("Unknown power schedule: %s.\n", config.powerSched)
+	}
+	switch config.mutOpts.splice {
+	case "", spliceRandom, spliceFar, spliceRegion:
+	default:
+		log.Fatalf("Unknown splicing partner policy: %s.\n",
+			config.mutOpts.splice)
 	}
 
 	createOutDir(config.outDir)
@@ -136,6 +328,9 @@ func readSeeds(dir string) (seedInputs [][]byte) {
 	}
 
 	for _, info := range infos {
+		if filepath.Ext(info.Name()) == ".meta" { // Sidecar of a saved seed.
+			continue
+		}
 		in, err := ioutil.ReadFile(filepath.Join(dir, info.Name()))
 		if err != nil {
 			log.Printf("Couldn't read seed %s: %v.\n", info.Name(), err)
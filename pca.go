@@ -24,12 +24,12 @@ import (
 
 type dynamicPCA struct {
 	// Y = (X-center)' * basis
-	centers [mapSize]float64
+	centers []float64
 	basis   *mat.Dense
 
 	sampleN int
 	sqNorm  float64
-	sums    [mapSize]float64
+	sums    []float64
 	covMat  *mat.Dense // Covariance Matrix; cumulative
 
 	// Phase-based initialization
@@ -38,7 +38,10 @@ type dynamicPCA struct {
 }
 
 func newDynPCA(queue [][]byte) (ok bool, dynpca *dynamicPCA) {
-	dynpca = new(dynamicPCA)
+	dynpca = &dynamicPCA{
+		centers: make([]float64, mapSize),
+		sums:    make([]float64, mapSize),
+	}
 
 	// ** 1. Compute centers **
 	for _, trace := range queue {
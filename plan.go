@@ -0,0 +1,417 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+)
+
+// *****************************************************************************
+// ***************************** Campaign Plan *********************************
+// The phases of a campaign, and what is exported at the end, can be given in
+// a JSON file (-plan) instead of recompiling. E.g., the default plan:
+//   {
+//     "phases": [
+//       {"name": "fuzz", "kind": "fuzz", "fitness": ["brcov", "pca", "freq"]},
+//       {"name": "divergence", "kind": "divergence"}
+//     ],
+//     "evolution": true,
+//     "log_freq": false,
+//     "exports": ["projection", "stability", "seeds", "lineage", "energy",
+//                 "regions", "histos"]
+//   }
+// A phase fuzzes all the seeds for its number of rounds ("rounds", -rounds by
+// default) with its power schedule ("power", -power by default). Its fitness
+// stack is used for the seeds first fuzzed in the phase. If set, its mutator
+// ("mutator") replaces the one of all the seeds. Havoc stacks at most "stack"
+// mutations (-stack by default). A divergence phase first
+// computes the global basis and its regions, and adds the divergence fitness
+// to the seeds (at most one).
+
+const (
+	phaseFuzz       = "fuzz"
+	phaseDivergence = "divergence"
+
+	unlimitedRounds = -1 // Until the campaign budget is exhausted.
+
+	fitnessBrCov   = "brcov"
+	fitnessPCA     = "pca"
+	fitnessFreq    = "freq" // Global path frequencies (f1/f2, power).
+	fitnessEntropy = "entropy"
+
+	outProjection = "projection" // pcas, frequencies, hashes, distances...
+	outStability  = "stability"
+	outSeeds      = "seeds"
+	outLineage    = "lineage"
+	outEnergies   = "energy"
+	outRegions    = "regions"
+	outHistos     = "histos"
+)
+
+type phasePlan struct {
+	Name    string   `json:"name"`
+	Kind    string   `json:"kind"`
+	Fitness []string `json:"fitness,omitempty"`
+	Mutator string   `json:"mutator,omitempty"`
+	Power   string   `json:"power,omitempty"`
+	Rounds  int      `json:"rounds,omitempty"`
+	Stack   int      `json:"stack,omitempty"`
+
+	index int // In the campaign plan.
+}
+
+type campaignPlan struct {
+	Phases []phasePlan `json:"phases"`
+	// If false, no seed is added once there is one per thread.
+	Evolution *bool `json:"evolution,omitempty"`
+	// Log per-seed hash frequencies for MLE divergence estimation.
+	LogFreq bool     `json:"log_freq,omitempty"`
+	Exports []string `json:"exports,omitempty"`
+}
+
+func defaultPlan() campaignPlan {
+	return campaignPlan{
+		Phases: []phasePlan{
+			{Name: phaseFuzz, Kind: phaseFuzz,
+				Fitness: []string{fitnessBrCov, fitnessPCA, fitnessFreq}},
+			{Name: phaseDivergence, Kind: phaseDivergence},
+		},
+	}
+}
+
+func loadPlan(path string) (plan campaignPlan, ok bool) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Printf("Couldn't read campaign plan: %v.\n", err)
+		return plan, ok
+	}
+	err = json.Unmarshal(content, &plan)
+	if err != nil {
+		log.Printf("Couldn't parse campaign plan: %v.\n", err)
+		return plan, ok
+	}
+	ok = true
+	return plan, ok
+}
+
+// Fill in the defaults (rounds, power and havoc stack from the CLI) and check
+// the plan.
+func (plan *campaignPlan) normalize(rounds int, power string, stack int) (
+	ok bool) {
+
+	if len(plan.Phases) == 0 {
+		log.Println("Campaign plan has no phase.")
+		return ok
+	} else if plan.Phases[0].Kind != phaseFuzz {
+		log.Println("First phase must fuzz (the global basis needs seeds).")
+		return ok
+	}
+	if plan.Evolution == nil {
+		evolution := true
+		plan.Evolution = &evolution
+	}
+	if len(plan.Exports) == 0 {
+		plan.Exports = []string{outProjection, outStability, outSeeds,
+			outLineage, outEnergies, outRegions, outHistos}
+	}
+	if rounds == 0 && plan.LogFreq {
+		rounds = fuzzRoundNLogFreq
+	} else if rounds == 0 {
+		rounds = fuzzRoundN
+	}
+
+	divN := 0
+	for i := range plan.Phases {
+		phase := &plan.Phases[i]
+		phase.index = i
+		if phase.Kind == phaseDivergence {
+			divN++
+		}
+		if len(phase.Name) == 0 {
+			phase.Name = fmt.Sprintf("phase-%d", i)
+		}
+		if phase.Kind != phaseFuzz && phase.Kind != phaseDivergence {
+			log.Printf("Phase %s: unknown kind %q.\n", phase.Name, phase.Kind)
+			return ok
+		}
+		if phase.Kind == phaseDivergence && !plan.usesBefore(i, fitnessPCA) {
+			log.Printf("Phase %s: divergence needs the %s fitness in an "+
+				"earlier phase (global basis).\n", phase.Name, fitnessPCA)
+			return ok
+		}
+		if phase.Rounds == 0 {
+			phase.Rounds = rounds
+		} else if phase.Rounds < 0 && phase.Rounds != unlimitedRounds {
+			log.Printf("Phase %s: negative rounds.\n", phase.Name)
+			return ok
+		}
+		if len(phase.Power) == 0 {
+			phase.Power = power
+		} else if !validPowerSched(phase.Power) {
+			log.Printf("Phase %s: unknown power schedule %s.\n", phase.Name,
+				phase.Power)
+			return ok
+		}
+		if len(phase.Mutator) > 0 && !validMutKind(phase.Mutator) {
+			log.Printf("Phase %s: unknown mutator %s.\n", phase.Name,
+				phase.Mutator)
+			return ok
+		}
+		if phase.Stack == 0 {
+			phase.Stack = stack
+		}
+		if phase.Stack < 1 {
+			log.Printf("Phase %s: havoc stack must be at least 1.\n",
+				phase.Name)
+			return ok
+		}
+
+		if len(phase.Fitness) == 0 {
+			phase.Fitness = defaultPlan().Phases[0].Fitness
+		}
+		for _, name := range phase.Fitness {
+			switch name {
+			case fitnessBrCov, fitnessPCA, fitnessFreq, fitnessEntropy:
+			default:
+				log.Printf("Phase %s: unknown fitness function %s.\n",
+					phase.Name, name)
+				return ok
+			}
+		}
+		// Needed by the power schedule.
+		if name := powerFitness(phase.Power); len(name) > 0 &&
+			!plan.uses(i, name) {
+			phase.Fitness = append(phase.Fitness, name)
+		}
+	}
+
+	if divN > 1 {
+		log.Println("Campaign plan has more than one divergence phase.")
+		return ok
+	}
+
+	for _, name := range plan.Exports {
+		switch name {
+		case outProjection, outStability, outSeeds, outLineage,
+			outEnergies, outRegions, outHistos:
+		default:
+			log.Printf("Unknown export: %s.\n", name)
+			return ok
+		}
+	}
+
+	ok = true
+	return ok
+}
+
+// With a campaign budget and no rounds given, the last phase runs until the
+// budget is exhausted (unless the plan gives its rounds).
+func (plan *campaignPlan) unboundLastPhase() {
+	if len(plan.Phases) == 0 {
+		return
+	} else if last := &plan.Phases[len(plan.Phases)-1]; last.Rounds == 0 {
+		last.Rounds = unlimitedRounds
+	}
+}
+
+func (plan campaignPlan) uses(phaseI int, fitness string) bool {
+	for _, name := range plan.Phases[phaseI].Fitness {
+		if name == fitness {
+			return true
+		}
+	}
+	return false
+}
+
+func (plan campaignPlan) usesBefore(phaseI int, fitness string) bool {
+	for i := 0; i < phaseI; i++ {
+		if plan.uses(i, fitness) {
+			return true
+		}
+	}
+	return false
+}
+
+func (plan campaignPlan) exports(name string) bool {
+	for _, export := range plan.Exports {
+		if export == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether some phase fuzzes with havoc (mutator of the phase, or defKind).
+func (plan campaignPlan) usesHavoc(defKind string) bool {
+	for _, phase := range plan.Phases {
+		kind := phase.Mutator
+		if len(kind) == 0 {
+			kind = defKind
+		}
+		if kind == havocMutKind || kind == mixedMutKind {
+			return true
+		}
+	}
+	return false
+}
+
+func (plan campaignPlan) tracksFreqs() bool {
+	for i := range plan.Phases {
+		if plan.uses(i, fitnessFreq) {
+			return true
+		}
+	}
+	return false
+}
+
+// Set the experiment switches.
+func (plan campaignPlan) apply() {
+	useEvoA, logFreq = *plan.Evolution, plan.LogFreq
+	trackGlbFreqs = plan.tracksFreqs()
+	powerSched = plan.Phases[len(plan.Phases)-1].Power
+}
+
+func (plan campaignPlan) save(path string) {
+	content, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		log.Printf("Couldn't encode campaign plan: %v.\n", err)
+		return
+	}
+	saveLines(path, []string{string(content)})
+}
+
+func newFitnessStack(names []string) (fm fitnessMultiplexer) {
+	for _, name := range names {
+		switch name {
+		case fitnessBrCov:
+			fm = append(fm, newBrCovFitFunc())
+		case fitnessPCA:
+			fm = append(fm, newPCAFitFunc())
+		case fitnessFreq:
+			fm = append(fm, freqFitFunc{})
+		case fitnessEntropy:
+			fm = append(fm, newEntropyFitFunc())
+		}
+	}
+	return fm
+}
+
+// Fitness function the power schedule needs, if any.
+func powerFitness(power string) string {
+	switch power {
+	case powerFast, powerCOE:
+		return fitnessFreq
+	case powerEntropic:
+		return fitnessEntropy
+	}
+	return ""
+}
+
+// Seeds of the earlier phases keep their fitness stack: it gets the fitness
+// function the power schedule of the phase needs, if it lacks it.
+func addPowerFitness(seeds []*seedT, power string) {
+	name := powerFitness(power)
+	if len(name) == 0 {
+		return
+	}
+	for _, seed := range seeds {
+		if seed.exec == nil {
+			continue
+		}
+		fm, ok := seed.exec.discoveryFit.(fitnessMultiplexer)
+		if !ok {
+			continue
+		}
+		found := false
+		for _, ff := range fm {
+			switch ff.(type) {
+			case freqFitFunc:
+				found = found || name == fitnessFreq
+			case *entropyFitFunc:
+				found = found || name == fitnessEntropy
+			}
+		}
+		if !found {
+			seed.exec.discoveryFit = append(fm,
+				newFitnessStack([]string{name})...)
+		}
+	}
+}
+
+// *****************************************************************************
+// ******************************** Execution **********************************
+
+type campaignResults struct {
+	seeds  []*seedT
+	finder *regionFinder // Of the divergence phase, if any.
+}
+
+func runPlan(plan campaignPlan, threads []*thread, initSeeds []*seedT) (
+	res campaignResults) {
+
+	res.seeds = initSeeds
+	for i, phase := range plan.Phases {
+		if i > 0 && wasInterrupted() {
+			break
+		}
+		rounds := fmt.Sprintf("%d rounds", phase.Rounds)
+		if phase.Rounds == unlimitedRounds {
+			rounds = "until the budget is exhausted"
+		}
+		fmt.Printf("\nPhase %d: %s (%s, %s, %s schedule).\n", i,
+			phase.Name, phase.Kind, rounds, phase.Power)
+		for _, seed := range res.seeds {
+			seed.execN = 0
+		}
+		addPowerFitness(res.seeds, phase.Power)
+
+		if phase.Kind == phaseDivergence {
+			ok, glbProj := doGlbProjection(res.seeds)
+			if !ok {
+				log.Println("Couldn't get global basis. Divergence phase " +
+					"aborted.")
+				continue
+			}
+			okDFF, finder := appendDivFitFunc(res.seeds, glbProj)
+			if !okDFF {
+				continue
+			}
+			seedPool.setProjections(glbProj, finder.regions)
+			rewardFinder, res.finder = &finder, &finder
+			didDivPhase = true
+			fmt.Println("")
+		}
+		res.seeds = fuzzLoop(threads, res.seeds, phase)
+	}
+	return res
+}
+
+func exportResults(plan campaignPlan, res campaignResults,
+	stab stabilityInfo, outDir string) {
+
+	seeds := res.seeds
+	if res.finder != nil && plan.exports(outRegions) {
+		res.finder.export(outDir)
+	}
+	if didDivPhase && plan.exports(outHistos) {
+		checkHistos(seeds)
+	}
+	if plan.exports(outProjection) {
+		export(outDir, seeds)
+	}
+	if plan.exports(outStability) {
+		exportStability(stab, filepath.Join(outDir, "stability.csv"))
+	}
+	if plan.exports(outSeeds) {
+		saveSeeds(outDir, seeds)
+	}
+	if plan.exports(outLineage) {
+		exportLineage(seeds, filepath.Join(outDir, "lineage.csv"))
+	}
+	if plan.exports(outEnergies) {
+		exportEnergy(seeds, filepath.Join(outDir, "energy.csv"))
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestPlanNormalize(t *testing.T) {
+	plan := defaultPlan()
+	if !plan.normalize(3, powerUniform, havocStackMax) {
+		t.Fatal("Invalid default plan.")
+	}
+	if !*plan.Evolution || len(plan.Exports) != 7 {
+		t.Errorf("Wrong defaults: evolution %v, exports %v.", *plan.Evolution,
+			plan.Exports)
+	}
+	for _, phase := range plan.Phases {
+		if phase.Rounds != 3 || phase.Power != powerUniform ||
+			len(phase.Fitness) != 3 || phase.Stack != havocStackMax {
+			t.Errorf("Wrong phase defaults: %+v.", phase)
+		}
+	}
+
+	tests := []struct {
+		phases []phasePlan
+		ok     bool
+	}{
+		{nil, false},
+		{[]phasePlan{{Kind: phaseDivergence}}, false},
+		{[]phasePlan{{Kind: "sleep"}}, false},
+		{[]phasePlan{{Kind: phaseFuzz, Fitness: []string{"cov"}}}, false},
+		{[]phasePlan{{Kind: phaseFuzz, Mutator: "bitflip"}}, false},
+		{[]phasePlan{{Kind: phaseFuzz, Power: "slow"}}, false},
+		{[]phasePlan{{Kind: phaseFuzz, Rounds: -2}}, false},
+		{[]phasePlan{{Kind: phaseFuzz, Rounds: unlimitedRounds}}, true},
+		{[]phasePlan{{Kind: phaseFuzz, Stack: -1}}, false},
+		{[]phasePlan{{Kind: phaseFuzz}, {Kind: phaseDivergence},
+			{Kind: phaseDivergence}}, false},
+		{[]phasePlan{{Kind: phaseFuzz, Fitness: []string{fitnessBrCov}},
+			{Kind: phaseDivergence}}, false}, // No global basis.
+		{[]phasePlan{{Kind: phaseFuzz, Mutator: mixedMutKind, Stack: 4},
+			{Kind: phaseDivergence}, {Kind: phaseFuzz}}, true},
+	}
+	for i, test := range tests {
+		plan := campaignPlan{Phases: test.phases}
+		ok := plan.normalize(1, powerUniform, havocStackMax)
+		if ok != test.ok {
+			t.Errorf("Test %d: normalize is %v, expected %v.", i, ok, test.ok)
+		}
+	}
+}
+
+// With a budget and no rounds, only the last phase is unbounded.
+func TestPlanUnbounded(t *testing.T) {
+	if (budgetOptions{}).bounded() || !(budgetOptions{minRate: 1}).bounded() {
+		t.Error("Wrong bounded budgets.")
+	}
+	plan := defaultPlan()
+	plan.unboundLastPhase()
+	if !plan.normalize(0, powerUniform, havocStackMax) {
+		t.Fatal("Invalid plan.")
+	}
+	if plan.Phases[0].Rounds != fuzzRoundN ||
+		plan.Phases[1].Rounds != unlimitedRounds {
+		t.Errorf("Rounds are %d and %d, expected %d and unlimited.",
+			plan.Phases[0].Rounds, plan.Phases[1].Rounds, fuzzRoundN)
+	}
+
+	plan = campaignPlan{Phases: []phasePlan{{Kind: phaseFuzz, Rounds: 3}}}
+	plan.unboundLastPhase()
+	if plan.Phases[0].Rounds != 3 {
+		t.Error("Rounds given by the plan were unbounded.")
+	}
+}
+
+func TestPlanPowerFitness(t *testing.T) {
+	plan := campaignPlan{Phases: []phasePlan{
+		{Kind: phaseFuzz, Fitness: []string{fitnessBrCov}, Power: powerFast},
+		{Kind: phaseFuzz, Fitness: []string{fitnessBrCov}},
+	}}
+	if !plan.normalize(1, powerEntropic, havocStackMax) {
+		t.Fatal("Invalid plan.")
+	}
+	if !plan.uses(0, fitnessFreq) || !plan.uses(1, fitnessEntropy) {
+		t.Errorf("Power schedule fitness functions not added: %+v.",
+			plan.Phases)
+	}
+	if !plan.tracksFreqs() {
+		t.Error("Global path frequencies not tracked.")
+	}
+
+	// The seeds of the first phase get the fitness function of the second
+	// phase power schedule, once.
+	seed := &seedT{exec: &executor{
+		discoveryFit: newFitnessStack(plan.Phases[0].Fitness)}}
+	for i := 0; i < 2; i++ {
+		addPowerFitness([]*seedT{seed}, plan.Phases[1].Power)
+	}
+	if ok, _ := getEntropyFF(seed); !ok {
+		t.Error("Entropy fitness not added to the seed.")
+	} else if fm := seed.exec.discoveryFit.(fitnessMultiplexer); len(fm) !=
+		len(plan.Phases[0].Fitness)+1 {
+		t.Errorf("Seed fitness stack: %v.", fm)
+	}
+}
+
+// Seeds of an earlier phase get the mutator, or havoc stack, of the phase.
+func TestPhaseMutator(t *testing.T) {
+	defer func(conf mutatorOptions) { mutConf = conf }(mutConf)
+	plan := campaignPlan{Phases: []phasePlan{{Kind: phaseFuzz},
+		{Kind: phaseFuzz, Stack: 4}, {Kind: phaseFuzz, Mutator: ratioMutKind}}}
+	if !plan.normalize(1, powerUniform, havocStackMax) {
+		t.Fatal("Invalid plan.")
+	}
+	tests := []struct {
+		kind            string
+		phaseI          int
+		changes, stack4 bool
+	}{
+		{havocMutKind, 0, false, false},
+		{havocMutKind, 1, true, true},
+		{ratioMutKind, 1, false, false},
+		{havocMutKind, 2, true, false},
+	}
+	for i, test := range tests {
+		seed := &seedT{mutOpts: mutatorOptions{kind: test.kind,
+			stackMax: havocStackMax}}
+		sched := &scheduler{phase: plan.Phases[test.phaseI]}
+		if changes := sched.changesMutator(seed); changes != test.changes {
+			t.Errorf("Test %d: mutator change is %t.", i, changes)
+			continue
+		} else if !changes {
+			continue
+		}
+		mutConf.kind = test.kind
+		sched.makeMutator(seed)
+		if stack4 := seed.mutOpts.stackMax == 4; stack4 != test.stack4 {
+			t.Errorf("Test %d: rebuilt mutator options %+v.", i,
+				seed.mutOpts)
+		}
+	}
+}
+
+func TestLoadPlan(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plan.json")
+	content := `{"phases": [{"name": "havoc", "kind": "fuzz", ` +
+		`"mutator": "havoc", "rounds": 2}], "evolution": false, ` +
+		`"exports": ["seeds"]}`
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Couldn't write plan: %v.", err)
+	}
+
+	plan, ok := loadPlan(path)
+	if !ok || !plan.normalize(1, powerUniform, havocStackMax) {
+		t.Fatal("Couldn't load plan.")
+	}
+	phase := plan.Phases[0]
+	if phase.Name != "havoc" || phase.Mutator != havocMutKind ||
+		phase.Rounds != 2 || *plan.Evolution ||
+		!plan.exports(outSeeds) || plan.exports(outLineage) {
+		t.Errorf("Wrong plan: %+v.", plan)
+	}
+
+	// The saved effective plan loads back the same.
+	plan.save(path)
+	saved, ok := loadPlan(path)
+	if !ok || !saved.normalize(5, powerFast, havocStackMax) ||
+		saved.Phases[0].Rounds != 2 || saved.Phases[0].Power != powerUniform {
+		t.Errorf("Saved plan differs: %+v.", saved)
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"container/heap"
+	"math"
+	"sync"
+	"time"
+)
+
+// *****************************************************************************
+// ***************************** Power Schedules *******************************
+// Like AFLFast, the energy of a seed depends on statistics: how often its path
+// was hit (global trace hash frequencies), how much coverage it contributed
+// (seeds found while fuzzing it) and how fast it executes. Energies are
+// relative: the scheduler picks seeds in proportion to their energy (stride
+// scheduling), the number of rounds of each phase (maybe unlimited, see
+// campaignPlan) is unchanged.
+//  - uniform: all seeds get the same number of rounds.
+//  - explore: performance score only (speed, coverage contributed).
+//  - fast: rarely hit paths first, more as the seed gets picked.
+//  - coe: like fast, but seeds with a path hit more than average are skipped.
+//  - exploit: seeds which contributed coverage get the maximum factor.
+//  - entropic: like libFuzzer Entropic, the species entropy of the seed's test
+//    cases (relative to the average seed): seeds whose neighborhood is diverse
+//    have more to teach. Seeds not fuzzed yet are considered average.
+//  - region: in the divergence phase, the expected species discovery reward of
+//    the global basis regions the seed's test cases land in (relative to the
+//    average seed). Seeds stuck in saturated regions get fewer rounds. Before
+//    the divergence phase (no regions), like uniform.
+
+const (
+	powerUniform  = "uniform"
+	powerExplore  = "explore"
+	powerFast     = "fast"
+	powerCOE      = "coe"
+	powerExploit  = "exploit"
+	powerEntropic = "entropic"
+	powerRegion   = "region"
+)
+
+var (
+	powerSched = powerUniform
+	// Regions of the divergence phase, for the region schedule (nil before).
+	rewardFinder *regionFinder
+)
+
+func validPowerSched(sched string) bool {
+	switch sched {
+	case powerUniform, powerExplore, powerFast, powerCOE, powerExploit,
+		powerEntropic, powerRegion:
+		return true
+	}
+	return false
+}
+
+// ** Path frequencies **
+
+type hashCounter struct {
+	mtx    sync.RWMutex
+	counts map[uint64]uint32
+}
+
+// Updated by the global frequency tracker (see trackGlbFreqs).
+var pathFreqs = &hashCounter{counts: make(map[uint64]uint32)}
+
+func (hc *hashCounter) inc(hash uint64) (freq uint32) {
+	hc.mtx.Lock()
+	freq = hc.counts[hash] + 1
+	hc.counts[hash] = freq
+	hc.mtx.Unlock()
+	return freq
+}
+
+func (hc *hashCounter) get(hash uint64) uint32 {
+	hc.mtx.RLock()
+	defer hc.mtx.RUnlock()
+	return hc.counts[hash]
+}
+
+// ** Energy **
+
+type powerStats struct {
+	meanExecTime time.Duration
+	meanHits     float64
+	meanReward   float64 // Of the seeds with a region reward.
+	meanEntropy  float64 // Of the seeds with an entropy estimate.
+}
+
+func getPowerStats(seeds []*seedT) (stats powerStats) {
+	if len(seeds) == 0 {
+		return stats
+	}
+	var execTSum time.Duration
+	var hitSum float64
+	for _, seed := range seeds {
+		execTSum += seed.execTime
+		hitSum += float64(pathFreqs.get(seed.hash))
+	}
+	stats.meanExecTime = execTSum / time.Duration(len(seeds))
+	stats.meanHits = hitSum / float64(len(seeds))
+
+	var entropySum float64
+	var entropyN int
+	for _, seed := range seeds {
+		if ok, eff := getEntropyFF(seed); ok {
+			if entropy, okE := eff.entropy(); okE {
+				entropySum += entropy
+				entropyN++
+			}
+		}
+	}
+	if entropyN > 0 {
+		stats.meanEntropy = entropySum / float64(entropyN)
+	}
+
+	if rewardFinder != nil {
+		var rewardSum float64
+		var rewardN int
+		for _, seed := range seeds {
+			if reward, ok := rewardFinder.seedReward(seed.hash); ok {
+				rewardSum += reward
+				rewardN++
+			}
+		}
+		if rewardN > 0 {
+			stats.meanReward = rewardSum / float64(rewardN)
+		}
+	}
+	return stats
+}
+
+func seedEnergy(seed *seedT, sched string, stats powerStats) (energy float64) {
+	if sched == powerUniform {
+		return 1
+	} else if sched == powerRegion {
+		return regionEnergy(seed, stats)
+	} else if sched == powerEntropic {
+		return entropicEnergy(seed, stats)
+	}
+
+	hits := float64(pathFreqs.get(seed.hash))
+	if hits < 1 {
+		hits = 1
+	}
+	fastFactor := func() float64 {
+		if seed.execN < 16 {
+			return math.Min(math.Exp2(float64(seed.execN))/hits,
+				powerMaxFactor)
+		}
+		return powerMaxFactor / math.Exp2(math.Ceil(math.Log2(hits)))
+	}
+
+	factor := 1.0
+	switch sched {
+	case powerFast:
+		factor = fastFactor()
+	case powerCOE:
+		if hits > stats.meanHits {
+			factor = 0
+		} else {
+			factor = fastFactor()
+		}
+	case powerExploit:
+		if seed.childN > 0 {
+			factor = powerMaxFactor
+		}
+	}
+
+	energy = perfScore(seed, stats) * factor
+	if energy < powerMinEnergy {
+		energy = powerMinEnergy
+	}
+	return energy
+}
+
+// Seeds without sample yet are optimistically considered average.
+func regionEnergy(seed *seedT, stats powerStats) (energy float64) {
+	if rewardFinder == nil || stats.meanReward == 0 {
+		return 1
+	}
+	reward, ok := rewardFinder.seedReward(seed.hash)
+	if !ok {
+		return 1
+	}
+	return boundEnergy(reward / stats.meanReward)
+}
+
+func entropicEnergy(seed *seedT, stats powerStats) (energy float64) {
+	ok, eff := getEntropyFF(seed)
+	if !ok || stats.meanEntropy == 0 {
+		return 1
+	}
+	entropy, ok := eff.entropy()
+	if !ok {
+		return 1
+	}
+	return boundEnergy(entropy / stats.meanEntropy)
+}
+
+func boundEnergy(energy float64) float64 {
+	if energy > powerMaxFactor {
+		energy = powerMaxFactor
+	} else if energy < powerMinEnergy {
+		energy = powerMinEnergy
+	}
+	return energy
+}
+
+// Like AFL calculate_score: 1 is average; faster seeds and seeds which
+// contributed coverage get more.
+func perfScore(seed *seedT, stats powerStats) (perf float64) {
+	perf = 1
+	execT, meanT := float64(seed.execTime), float64(stats.meanExecTime)
+	switch {
+	case meanT == 0 || execT == 0:
+	case execT*0.1 > meanT:
+		perf = 0.1
+	case execT*0.25 > meanT:
+		perf = 0.25
+	case execT*0.5 > meanT:
+		perf = 0.5
+	case execT*0.75 > meanT:
+		perf = 0.75
+	case execT*4 < meanT:
+		perf = 3
+	case execT*3 < meanT:
+		perf = 2
+	case execT*2 < meanT:
+		perf = 1.5
+	}
+
+	switch {
+	case seed.childN >= 8:
+		perf *= 4
+	case seed.childN >= 4:
+		perf *= 3
+	case seed.childN >= 1:
+		perf *= 2
+	}
+	return perf
+}
+
+// *****************************************************************************
+// ******************************* Seed Queue **********************************
+// Priority queue of the seeds waiting for a thread: the one with the smallest
+// number of rounds over energy first (then the oldest).
+
+type queuedSeed struct {
+	seed *seedT
+	prio float64
+	seq  int
+}
+
+type seedQueue struct {
+	items []queuedSeed
+	seqN  int
+}
+
+func (sq *seedQueue) Len() int { return len(sq.items) }
+func (sq *seedQueue) Less(i, j int) bool {
+	a, b := sq.items[i], sq.items[j]
+	if a.prio != b.prio {
+		return a.prio < b.prio
+	}
+	return a.seq < b.seq
+}
+func (sq *seedQueue) Swap(i, j int) {
+	sq.items[i], sq.items[j] = sq.items[j], sq.items[i]
+}
+func (sq *seedQueue) Push(x interface{}) {
+	sq.items = append(sq.items, x.(queuedSeed))
+}
+func (sq *seedQueue) Pop() interface{} {
+	l := len(sq.items)
+	item := sq.items[l-1]
+	sq.items = sq.items[:l-1]
+	return item
+}
+
+func seedPriority(seed *seedT) float64 {
+	return float64(seed.execN) / seed.energy
+}
+
+func (sq *seedQueue) push(seed *seedT) {
+	heap.Push(sq, queuedSeed{seed: seed, prio: seedPriority(seed),
+		seq: sq.seqN})
+	sq.seqN++
+}
+
+func (sq *seedQueue) pop() (seed *seedT, ok bool) {
+	if len(sq.items) == 0 {
+		return seed, ok
+	}
+	return heap.Pop(sq).(queuedSeed).seed, true
+}
+
+// Recompute the energies of the queued seeds (their statistics changed).
+func (sq *seedQueue) refresh(sched string, stats powerStats) {
+	for i := range sq.items {
+		seed := sq.items[i].seed
+		seed.energy = seedEnergy(seed, sched, stats)
+		sq.items[i].prio = seedPriority(seed)
+	}
+	heap.Init(sq)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSeedEnergy(t *testing.T) {
+	rare := &seedT{runT: runT{hash: 0xa11ce, execTime: time.Millisecond}}
+	common := &seedT{runT: runT{hash: 0xb0b, execTime: time.Millisecond}}
+	pathFreqs.inc(rare.hash)
+	for i := 0; i < 100; i++ {
+		pathFreqs.inc(common.hash)
+	}
+	stats := getPowerStats([]*seedT{rare, common})
+
+	if e := seedEnergy(common, powerUniform, stats); e != 1 {
+		t.Errorf("Uniform energy is %v.", e)
+	}
+	if seedEnergy(rare, powerFast, stats) <= seedEnergy(common, powerFast,
+		stats) {
+		t.Error("Fast schedule doesn't favor the rare path.")
+	}
+	if e := seedEnergy(common, powerCOE, stats); e != powerMinEnergy {
+		t.Errorf("COE energy of a frequent path is %v.", e)
+	}
+
+	// Faster, and contributed coverage.
+	fast := &seedT{runT: runT{hash: 0xb0b, execTime: time.Microsecond}}
+	fast.childN = 1
+	stats.meanExecTime = time.Millisecond
+	if e := seedEnergy(fast, powerExplore, stats); e != 6 {
+		t.Errorf("Explore energy is %v, expected 6.", e)
+	}
+	if e := seedEnergy(fast, powerExploit, stats); e != 6*powerMaxFactor {
+		t.Errorf("Exploit energy is %v, expected %v.", e, 6*powerMaxFactor)
+	}
+}
+
+// Seeds are picked in proportion to their energy.
+func TestSeedQueue(t *testing.T) {
+	queue := &seedQueue{}
+	seeds := []*seedT{{energy: 1}, {energy: 2}, {energy: 1}}
+	for _, seed := range seeds {
+		queue.push(seed)
+	}
+
+	picks := make(map[*seedT]int)
+	for i := 0; i < 40; i++ {
+		seed, ok := queue.pop()
+		if !ok {
+			t.Fatal("Empty queue.")
+		}
+		picks[seed]++
+		seed.execN++
+		queue.push(seed)
+	}
+	if picks[seeds[0]] != 10 || picks[seeds[1]] != 20 || picks[seeds[2]] != 10 {
+		t.Errorf("Seeds picked %d, %d and %d times.", picks[seeds[0]],
+			picks[seeds[1]], picks[seeds[2]])
+	}
+}
+
+// Seeds landing in a saturated region get less energy.
+func TestRegionEnergy(t *testing.T) {
+	defer func(rf *regionFinder) { rewardFinder = rf }(rewardFinder)
+	finder := regionFinder{
+		mtx:     &sync.RWMutex{},
+		regions: []regionT{makeRegion([]float64{0}), makeRegion([]float64{1})},
+		seedRegionCnt: map[uint64][]int{
+			1: {10, 0},
+			2: {2, 8},
+		},
+	}
+	finder.regions[0].speciesN, finder.regions[0].sampleN = 1, 100
+	rewardFinder = &finder
+
+	saturated, fresh := &seedT{runT: runT{hash: 1}}, &seedT{runT: runT{hash: 2}}
+	unknown := &seedT{runT: runT{hash: 3}}
+	stats := getPowerStats([]*seedT{saturated, fresh, unknown})
+	eS := seedEnergy(saturated, powerRegion, stats)
+	eF := seedEnergy(fresh, powerRegion, stats)
+	if eS >= 1 || eF <= 1 {
+		t.Errorf("Saturated seed energy: %v, fresh seed energy: %v.", eS, eF)
+	}
+	if e := seedEnergy(unknown, powerRegion, stats); e != 1 {
+		t.Errorf("Seed without sample has energy %v.", e)
+	}
+
+	rewardFinder = nil
+	if e := seedEnergy(saturated, powerRegion, stats); e != 1 {
+		t.Errorf("Energy before the divergence phase is %v.", e)
+	}
+}
+
+// Seeds whose test cases have diverse paths get more energy.
+func TestEntropicEnergy(t *testing.T) {
+	newSeed := func(hash uint64, pathN int) *seedT {
+		eff := newEntropyFitFunc()
+		for i := 0; i < 100; i++ {
+			eff.isFit(runT{hash: uint64(i % pathN)})
+		}
+		return &seedT{runT: runT{hash: hash},
+			exec: &executor{discoveryFit: fitnessMultiplexer{eff}}}
+	}
+	diverse, uniform := newSeed(1, 50), newSeed(2, 1)
+	unfuzzed := &seedT{runT: runT{hash: 3}, exec: &executor{
+		discoveryFit: fitnessMultiplexer{newEntropyFitFunc()}}}
+
+	_, eff := getEntropyFF(diverse)
+	if entropy, _ := eff.entropy(); math.Abs(entropy-3.93) > 0.01 {
+		t.Errorf("Entropy of 50 species seen twice is %v.", entropy)
+	}
+	stats := getPowerStats([]*seedT{diverse, uniform, unfuzzed})
+	eD := seedEnergy(diverse, powerEntropic, stats)
+	eU := seedEnergy(uniform, powerEntropic, stats)
+	if eD <= 1 || eU >= 1 {
+		t.Errorf("Diverse seed energy: %v, uniform seed energy: %v.", eD, eU)
+	}
+	if e := seedEnergy(unfuzzed, powerEntropic, stats); e != 1 {
+		t.Errorf("Unfuzzed seed has energy %v.", e)
+	}
+
+	// Beyond the tracked species, new ones count as seen once.
+	full := newEntropyFitFunc()
+	for i := 0; i < 2*entropyMaxSpecies; i++ {
+		full.isFit(runT{hash: uint64(i)})
+	}
+	if len(full.freqs) != entropyMaxSpecies {
+		t.Errorf("%d species tracked, expected %d.", len(full.freqs),
+			entropyMaxSpecies)
+	}
+	n := float64(2*entropyMaxSpecies + 1)
+	if entropy, _ := full.entropy(); math.Abs(entropy-math.Log(n)) > 1e-9 {
+		t.Errorf("Entropy of %v singletons is %v.", n-1, entropy)
+	}
+}
@@ -7,11 +7,9 @@ import (
 	"encoding/binary"
 	"io"
 	"io/ioutil"
-	"math/rand"
 	"os"
 	"path/filepath"
 	"regexp"
-	"runtime"
 	"strconv"
 	"strings"
 	"sync"
@@ -28,29 +26,44 @@ import (
 var (
 	helloChildA = [4]byte{0, 0, 0, 0}
 	helloChildS = helloChildA[:]
+	// Tells the fork server the stopped (persistent) child was killed.
+	killedChildA = [4]byte{1, 0, 0, 0}
+	killedChildS = killedChildA[:]
 )
 
-func (put *aflPutT) run(testCase []byte) (runInfo runT, err error) {
+func (put *aflPutT) run(testCase []byte, timeout time.Duration) (
+	runInfo runT, err error) {
+
 	zeroShm(put.trace)
 
 	if len(testCase) > 0 {
-		_, err = put.writer.Write(testCase)
+		var n int
+		n, err = put.writer.Write(testCase)
 		if err != nil {
 			log.Printf("Could not write testCase: %v\n", err)
 			return runInfo, err
 		}
+		testCase = testCase[:n] // What the PUT gets (see shmIO).
 	} else {
 		fmt.Println("Empty testCase.")
 	}
 
 	// Start running
-	_, err = put.ctlPipeW.Write(helloChildS)
+	// In persistent mode, if we killed the last child while it was stopped, the
+	// fork server needs to know, so it doesn't try to resume it.
+	hello := helloChildS
+	if put.prevTimedOut {
+		hello = killedChildS
+	}
+	execStartT := time.Now()
+	_, err = put.ctlPipeW.Write(hello)
 	if err != nil {
 		log.Printf("Problem when writing in control pipe: %v\n", err)
 		return runInfo, err
 	}
 	encodedWorkpid := make([]byte, 4)
-	_, err = put.stPipeR.Read(encodedWorkpid)
+	put.stPipeR.SetReadDeadline(time.Now().Add(forksrvReplyTimeout))
+	_, err = io.ReadFull(put.stPipeR, encodedWorkpid)
 	if err != nil {
 		log.Printf("Problem when reading the status pipe: %v\n", err)
 		return runInfo, err
@@ -58,11 +71,12 @@ func (put *aflPutT) run(testCase []byte) (runInfo runT, err error) {
 	pid := int(binary.LittleEndian.Uint32(encodedWorkpid))
 
 	// Start run.
+	put.stPipeR.SetReadDeadline(time.Time{})
 	encodedStatus := make([]byte, 4)
 	reportChan := make(chan error)
-	timer := time.NewTimer(put.timeout)
+	timer := time.NewTimer(timeout)
 	go func() {
-		_, stErr := put.stPipeR.Read(encodedStatus)
+		_, stErr := io.ReadFull(put.stPipeR, encodedStatus)
 		reportChan <- stErr
 	}()
 
@@ -71,6 +85,7 @@ func (put *aflPutT) run(testCase []byte) (runInfo runT, err error) {
 	case err = <-reportChan:
 		timer.Stop()
 	case <-timer.C:
+		runInfo.hanged = true
 		p, errP := os.FindProcess(pid)
 		if errP != nil {
 			log.Printf("Could find child process run (pid=%d): %v.\n", pid, errP)
@@ -78,22 +93,31 @@ func (put *aflPutT) run(testCase []byte) (runInfo runT, err error) {
 			errP = p.Kill()
 			if errP != nil {
 				log.Printf("Could not kill process (pid=%d): %v.\n", pid, errP)
-			} else {
-				runInfo.hanged = true
 			}
 		}
+		// The fork server still reports the (killed) child status.
+		put.stPipeR.SetReadDeadline(time.Now().Add(forksrvReplyTimeout))
+		err = <-reportChan
 	}
+	runInfo.execTime = time.Now().Sub(execStartT)
 
-	if err != nil {
+	if err != nil { // Status is garbage.
 		log.Printf("Problem while reading status: %v.\n", err)
+		return runInfo, err
 	}
 
-	status := binary.LittleEndian.Uint32(encodedStatus)
-	runInfo.status = syscall.WaitStatus(status)
-	if stat := syscall.WaitStatus(status); stat.Signaled() {
+	stat := syscall.WaitStatus(binary.LittleEndian.Uint32(encodedStatus))
+	runInfo.status = stat
+	put.prevTimedOut = runInfo.hanged
+	if runInfo.hanged {
+		// Killed by us, so not a crash.
+	} else if stat.Stopped() {
+		// Persistent child finished one iteration (raised SIGSTOP) and waits
+		// for the next one.
+	} else if stat.Signaled() {
 		runInfo.crashed = true
 		runInfo.sig = stat.Signal()
-	} else if put.usesMsan && stat.ExitStatus() == msanError {
+	} else if put.feats.usesMsan && stat.ExitStatus() == msanError {
 		runInfo.crashed = true
 	}
 
@@ -102,12 +126,23 @@ func (put *aflPutT) run(testCase []byte) (runInfo runT, err error) {
 	return runInfo, err
 }
 
+func (put *aflPutT) getTrace() []byte { return put.trace }
+
+func (put *aflPutT) capabilities() targetCaps {
+	return targetCaps{
+		coverage:   true,
+		persistent: put.feats.persistent,
+		shmFuzz:    put.fsrvOpts.shmFuzz && !put.opts.noShmFuzz,
+		standalone: true,
+	}
+}
+
 // *****************************************************************************
 // ********************************* Setup *************************************
 
 const (
-	mapSizePow2 = 16
-	mapSize     = 1 << mapSizePow2
+	mapSizePow2    = 16
+	defaultMapSize = 1 << mapSizePow2
 
 	ipcPrivate = 0
 	ipcCreat   = 0x200
@@ -115,6 +150,14 @@ const (
 	ipcRmid    = 0
 
 	forksrvFd = 198
+	// How long to wait for the fork server hello.
+	forksrvInitTimeout = 10 * time.Second
+	// How long to wait for the child pid, and for the status of a child killed
+	// on timeout: a fork server which doesn't reply is dead (restarted).
+	forksrvReplyTimeout = time.Second
+	// Retries (and wait in between) when the fork server start fails.
+	forksrvStartTry  = 3
+	forksrvStartWait = 100 * time.Millisecond
 
 	// Memory Sanitizer configuration usage, from AFL:
 	// "MSAN is tricky, because it doesn't support abort_on_error=1 at this
@@ -124,29 +167,52 @@ const (
 	msanError = 86
 
 	shmEnvVar        = "__AFL_SHM_ID"
+	shmFuzzEnvVar    = "__AFL_SHM_FUZZ_ID"
+	cmplogEnvVar     = "__AFL_CMPLOG_SHM_ID"
+	mapSizeEnvVar    = "AFL_MAP_SIZE"
 	persistentEnvVar = "__AFL_PERSISTENT"
 	deferEnvVar      = "__AFL_DEFER_FORKSRV"
 	asanVar          = "ASAN_OPTIONS"
 	msanVar          = "MSAN_OPTIONS"
+	ubsanVar         = "UBSAN_OPTIONS"
 
 	persistentSig = "##SIG_AFL_PERSISTENT##"
 	deferSig      = "##SIG_AFL_DEFER_FORKSRV##"
 	asanDetect    = "libasan.so"
 	msanDetect    = "__msan_init"
+	ubsanDetect   = "__ubsan_handle_"
 )
 
 type aflPutT struct {
 	trace []byte
+	opts  putOptions
 
 	// Used at each run
-	writer  putWriter
-	timeout time.Duration
+	writer putWriter
 
 	// System
 	pid               int
 	shmID             uintptr
-	usesMsan          bool
+	feats             binFeatures
+	fsrvOpts          forkserverOpts
 	ctlPipeW, stPipeR *os.File
+	sbxDir            string // Sandbox working directory (if any).
+
+	// Comparison map, if a cmplog binary (see cmplog.go).
+	cmpShmID uintptr
+	cmpMap   []byte
+
+	// Last child was killed. In persistent mode, the same (stopped) child runs
+	// several test cases, so the fork server has to be told.
+	prevTimedOut bool
+}
+
+// What was detected in the binary.
+type binFeatures struct {
+	usesMsan   bool
+	persistent bool
+	deferred   bool
+	shmFuzz    bool // Test cases can be read from shared memory.
 }
 
 type putWriter interface {
@@ -154,9 +220,43 @@ type putWriter interface {
 	clean()
 }
 
-func startAFLPUT(binPath string, cliArgs []string, timeout time.Duration) (
+func startAFLPUT(binPath string, cliArgs []string, opts putOptions) (
 	put *aflPutT, ok bool) {
 
+	shmSize := getMapSize()
+	for try := 0; try < forksrvStartTry && !ok; try++ {
+		if try > 0 {
+			time.Sleep(forksrvStartWait)
+		}
+		put, ok = launchAFLPUT(binPath, cliArgs, opts, shmSize)
+	}
+	if !ok {
+		return put, ok
+	}
+
+	// ** IV - Adapt to the PUT map size **
+	size := fixMapSize(put.fsrvOpts.mapSize)
+	if put.fsrvOpts.mapSize > size {
+		log.Printf("PUT map size (%d) is larger than the one in use (%d).\n",
+			put.fsrvOpts.mapSize, size)
+		put.clean()
+		return put, false
+	} else if size > shmSize { // Need a bigger shared memory: start again.
+		fmt.Printf("Restarting PUT with a map size of %d.\n", size)
+		put.clean()
+		put, ok = launchAFLPUT(binPath, cliArgs, opts, size)
+		if !ok {
+			return put, ok
+		}
+	}
+	put.trace = put.trace[:size]
+
+	return put, ok
+}
+
+func launchAFLPUT(binPath string, cliArgs []string, opts putOptions,
+	shmSize int) (put *aflPutT, ok bool) {
+
 	if _, err := os.Stat(binPath); os.IsNotExist(err) {
 		log.Printf("Wrong PUT path given: %s.\n", binPath)
 		return
@@ -179,36 +279,101 @@ func startAFLPUT(binPath string, cliArgs []string, timeout time.Duration) (
 	}
 
 	// ** II - Prepare binary launch **
-	okShm, shmID, trace := setupShm()
+	okShm, shmID, trace := setupShm(shmSize)
 	if !okShm {
 		log.Println("Couldn't setup shared memory with PUT.")
+		put.writer.clean()
 		return
 	}
 	put.trace, put.shmID = trace, shmID
 	env := os.Environ()
 	var extraEnv []string
-	extraEnv, put.usesMsan = getExtraEnvs(binPath, shmID)
+	extraEnv, put.feats = getExtraEnvs(binPath, shmID)
 	env = append(env, extraEnv...)
-	procAttr := &syscall.ProcAttr{
-		Env:   env,
-		Files: files,
-		Sys:   &syscall.SysProcAttr{Setsid: true},
+	env = append(env, fmt.Sprintf("%s=%d", mapSizeEnvVar, shmSize))
+	//
+	// Shared memory test case delivery (if the binary might support it).
+	var fuzzShm shmIO
+	useShmFuzz := put.feats.shmFuzz && !opts.noShmFuzz
+	if useShmFuzz {
+		useShmFuzz, fuzzShm = makeShmPUTWriter(put.writer)
+		if useShmFuzz {
+			env = append(env, fmt.Sprintf("%s=%d", shmFuzzEnvVar, fuzzShm.id))
+		}
+	}
+	//
+	// Comparison operands logging (cmplog companion binary).
+	if opts.cmplog {
+		okCmp, cmpShmID, cmpMap := setupShm(cmpMapSize)
+		if !okCmp {
+			log.Println("Couldn't setup cmplog shared memory.")
+			put.clean()
+			fuzzShm.close()
+			return
+		}
+		put.cmpShmID, put.cmpMap = cmpShmID, cmpMap
+		env = append(env, fmt.Sprintf("%s=%d", cmplogEnvVar, cmpShmID))
 	}
+	sysAttr := &syscall.SysProcAttr{Setsid: true}
+	if opts.sandbox {
+		sandboxSysProcAttr(sysAttr)
+	}
+	procAttr := &syscall.ProcAttr{Env: env, Files: files, Sys: sysAttr}
+	//
+	// Limits and sandbox are set by a Hemipt "launcher" (see sandbox.go).
+	okWrap, launchPath, launchArgs, sbxDir := wrapPUTLaunch(
+		binPath, cliArgs, opts)
+	if !okWrap {
+		log.Println("Couldn't prepare PUT limits/sandbox.")
+		put.clean()
+		fuzzShm.close()
+		return
+	}
+	put.sbxDir = sbxDir
 
 	// ** III - Launch binary **
-	okFrk, put.ctlPipeW, put.stPipeR, put.pid = initForkserver(binPath, cliArgs, procAttr)
+	okFrk, put.ctlPipeW, put.stPipeR, put.pid, put.fsrvOpts = initForkserver(
+		launchPath, launchArgs, procAttr)
 	if !okFrk {
 		log.Println("Problem starting fork server.")
+		put.clean()
+		fuzzShm.close()
+		return
+	}
+	//
+	useShmFuzz = useShmFuzz && put.fsrvOpts.shmFuzz
+	if !replyForkserverOpts(put.ctlPipeW, put.stPipeR, put.fsrvOpts,
+		useShmFuzz) {
+		put.clean()
+		fuzzShm.close()
 		return
 	}
+	if useShmFuzz {
+		put.writer = fuzzShm
+	} else { // Not supported after all.
+		fuzzShm.close()
+	}
 
-	put.timeout = timeout
+	put.opts = opts
 	ok = true
 
 	return put, ok
 }
 
-func getExtraEnvs(binPath string, shmID uintptr) (envs []string, usesMsan bool) {
+type putOptions struct {
+	timeout   time.Duration
+	noShmFuzz bool
+
+	// Resource limits (in MB, 0: none) and namespace sandbox.
+	memLimit, fsizeLimit uint64
+	sandbox              bool
+
+	cmplog bool // Binary logs comparison operands (AFL++ cmplog).
+}
+
+func getExtraEnvs(binPath string, shmID uintptr) (
+	envs []string, feats binFeatures) {
+
 	binContent, err := ioutil.ReadFile(binPath)
 	if err != nil {
 		log.Fatalf("Couldn't open the binary: %v.\n", err)
@@ -217,14 +382,16 @@ func getExtraEnvs(binPath string, shmID uintptr) (envs []string, usesMsan bool)
 	// Shared memory with the PUT to get the branch hit count.
 	reShm := regexp.MustCompile(shmEnvVar)
 	if !reShm.Match(binContent) {
-		log.Fatal("This binary wasn't instrumented correctly.")
+		log.Fatal("This binary wasn't instrumented correctly (see -dumb).")
 	}
 	envs = append(envs, fmt.Sprintf("%s=%d", shmEnvVar, shmID))
+	feats.shmFuzz = regexp.MustCompile(shmFuzzEnvVar).Match(binContent)
 	//
 	// Persistent mode
 	rePer := regexp.MustCompile(persistentSig)
 	if rePer.Match(binContent) {
 		fmt.Println("Persistent mode detected.")
+		feats.persistent = true
 		envs = append(envs, fmt.Sprintf("%s=1", persistentEnvVar))
 	}
 	//
@@ -232,18 +399,31 @@ func getExtraEnvs(binPath string, shmID uintptr) (envs []string, usesMsan bool)
 	reDef := regexp.MustCompile(deferSig)
 	if reDef.Match(binContent) {
 		fmt.Println("Deferred fork server detected.")
+		feats.deferred = true
 		envs = append(envs, fmt.Sprintf("%s=1", deferEnvVar))
 	}
 
+	// Dictionary tokens
+	addAutoTokens("binary", func() [][]byte { return binaryTokens(binContent) })
+
 	// Address and Memory SANitizers
+	sanEnvs, usesMsan := getSanitizerEnvs(binContent)
+	envs = append(envs, sanEnvs...)
+	feats.usesMsan = usesMsan
+
+	return envs, feats
+}
+
+// Sanitizers have to abort (and not symbolize, too slow) for us to see crashes.
+func getSanitizerEnvs(binContent []byte) (envs []string, usesMsan bool) {
 	reASAN := regexp.MustCompile(asanDetect)
 	reMSAN := regexp.MustCompile(msanDetect)
-	isAsan, isMsan := reASAN.Match(binContent), !reMSAN.Match(binContent)
-	if !isAsan && !isMsan {
+	isAsan, isMsan := reASAN.Match(binContent), reMSAN.Match(binContent)
+	isUbsan := regexp.MustCompile(ubsanDetect).Match(binContent)
+	if !isAsan && !isMsan && !isUbsan {
 		return envs, usesMsan
-	} else if isMsan {
-		usesMsan = true
 	}
+	usesMsan = isMsan
 	//
 	// ASAN
 	asanOps, ok := os.LookupEnv(asanVar)
@@ -270,24 +450,53 @@ func getExtraEnvs(binPath string, shmID uintptr) (envs []string, usesMsan bool)
 		envs = append(envs, fmt.Sprintf("%s=%s:symbolize=0:abort_on_error=1:"+
 			"allocator_may_return_null=1:msan_track_origins=0", msanVar, ec))
 	}
+	// UBSAN: otherwise, it only prints its findings (not crashes).
+	ubsanOps, ok := os.LookupEnv(ubsanVar)
+	if ok {
+		if !regexp.MustCompile("halt_on_error=1").MatchString(ubsanOps) ||
+			!regexp.MustCompile("abort_on_error=1").MatchString(ubsanOps) {
+			log.Fatal("Custom UBSAN_OPTIONS set without halt_on_error=1 and " +
+				"abort_on_error=1 - please fix!")
+		}
+	} else {
+		envs = append(envs, fmt.Sprintf("%s=halt_on_error=1:abort_on_error=1:"+
+			"print_stacktrace=1:symbolize=0", ubsanVar))
+	}
 
 	return envs, usesMsan
 }
 
+// Also used when the launch failed half-way: only clean what was set up.
 func (put *aflPutT) clean() {
-	killAllChildren(put.pid)
-	proc, err := os.FindProcess(put.pid)
-	if err != nil {
-		log.Printf("Could not get fork server process %d: %v.\n", put.pid, err)
-	} else {
-		err = proc.Kill()
+	if put.pid > 0 {
+		killAllChildren(put.pid)
+		proc, err := os.FindProcess(put.pid)
 		if err != nil {
-			log.Printf("Could not kill fork server: %v.\n", err)
+			log.Printf("Could not get fork server process %d: %v.\n", put.pid, err)
+		} else {
+			err = proc.Kill()
+			if err != nil {
+				log.Printf("Could not kill fork server: %v.\n", err)
+			}
+			proc.Wait() // Reap it (might already be dead).
 		}
+		put.pid = 0
+	}
+	if put.ctlPipeW != nil {
+		put.ctlPipeW.Close()
+		put.stPipeR.Close()
 	}
 
-	closeShm(put.shmID)
+	if put.trace != nil {
+		closeShm(put.shmID)
+	}
+	if put.cmpMap != nil {
+		closeShm(put.cmpShmID)
+	}
 	put.writer.clean()
+	if len(put.sbxDir) > 0 {
+		os.Remove(put.sbxDir) // tmpfs was only mounted in the PUT namespace.
+	}
 }
 
 func parseArgs(cliArgs []string) (
@@ -367,29 +576,40 @@ func makeFilePUTWriter(args []string, fileArg int, filePathPos [2]int) (
 	ok bool, pw putWriter, files []uintptr) {
 
 	// Setup
-	fileInName := filepath.Join(workDir, fmt.Sprintf("tmp-%x", rand.Int63()))
+	fileInName := filepath.Join(workDir, tmpName("tmp"))
 	ok, pw = true, newFileIO(fileInName)
 	files = []uintptr{devNull.Fd(), devNull.Fd(), devNull.Fd()}
 
 	// Prepare argument(s)
+	setFileArg(args, fileArg, filePathPos, fileInName)
+
+	return ok, pw, files
+}
+
+// Replace the "@@" sequence by the test case path.
+func setFileArg(args []string, fileArg int, filePathPos [2]int, path string) {
 	var newArg []byte
 	arg := args[fileArg]
 	if filePathPos[0] > 0 {
 		newArg = make([]byte, filePathPos[0])
 		copy(newArg, []byte(arg[:filePathPos[0]]))
 	}
-	newArg = append(newArg, []byte(fileInName)...)
+	newArg = append(newArg, []byte(path)...)
 	if filePathPos[1] != len(arg) {
 		newArg = append(newArg, []byte(arg[filePathPos[1]:])...)
 	}
 	args[fileArg] = string(newArg)
-
-	return ok, pw, files
 }
 
 // **************************
 
-type stdinIO struct{ *os.File }
+// If possible, the "file" only lives in memory (memfd). The PUT reads it
+// through its stdin which shares the file offset with us: after each write the
+// head is moved back to the start, so a persistent child can read again.
+type stdinIO struct {
+	*os.File
+	inMem bool
+}
 
 func (sio stdinIO) Write(tc []byte) (n int, err error) {
 	_, err = sio.File.Seek(0, os.SEEK_SET) // Reset head from last read.
@@ -413,6 +633,9 @@ func (sio stdinIO) clean() {
 	if errClose != nil {
 		log.Printf("Problem closing previous file descriptor: %v.\n", errClose)
 	}
+	if sio.inMem {
+		return
+	}
 	err := os.Remove(name)
 	if err != nil {
 		log.Printf("Could not close input file: %v\n", err)
@@ -420,28 +643,79 @@ func (sio stdinIO) clean() {
 }
 
 func makeStdinPUTWriter() (ok bool, pw putWriter, files []uintptr) {
-	fileInName := filepath.Join(workDir, fmt.Sprintf("tmp-%x", rand.Int63()))
-	// Need to use the system call directly because std library use O_CLOEXEC
-	// making impossible to pass this file to child.
-	fd, err := syscall.Open(fileInName, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
-	if err != nil {
-		log.Printf("Could not open %s: %v\n", fileInName, err)
-		return
+	fileInName := tmpName("tmp")
+	fd, err := unix.MemfdCreate(fileInName, 0)
+	inMem := err == nil
+	if !inMem {
+		fileInName = filepath.Join(workDir, fileInName)
+		// Need to use the system call directly because std library use O_CLOEXEC
+		// making impossible to pass this file to child.
+		fd, err = syscall.Open(fileInName, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			log.Printf("Could not open %s: %v\n", fileInName, err)
+			return
+		}
 	}
 	f := os.NewFile(uintptr(fd), fileInName)
 	//
-	ok, pw = true, stdinIO{File: f}
+	ok, pw = true, stdinIO{File: f, inMem: inMem}
 	files = []uintptr{f.Fd(), devNull.Fd(), devNull.Fd()}
 	return ok, pw, files
 }
 
+// **************************
+// AFL++ shared memory test case delivery: the PUT reads the length (4 bytes)
+// and the test case from a second shared memory segment. Used only if the fork
+// server accepts it; the file/stdin writer is kept as fallback. Longer test
+// cases are truncated (n is what was written, and recorded as the input).
+
+const maxShmFuzzLen = 1 << 20 // AFL++ MAX_FILE
+
+type shmIO struct {
+	id  uintptr
+	buf []byte
+
+	fallback putWriter
+}
+
+func (sio shmIO) Write(tc []byte) (n int, err error) {
+	n = len(tc)
+	if n > maxShmFuzzLen {
+		n = maxShmFuzzLen
+	}
+	binary.LittleEndian.PutUint32(sio.buf, uint32(n))
+	copy(sio.buf[4:], tc[:n])
+	return n, err
+}
+func (sio shmIO) clean() {
+	sio.close()
+	sio.fallback.clean()
+}
+
+// Only the shared memory (not the fallback).
+func (sio shmIO) close() {
+	if sio.buf != nil {
+		closeShm(sio.id)
+	}
+}
+
+func makeShmPUTWriter(fallback putWriter) (ok bool, pw shmIO) {
+	ok, id, buf := setupShm(maxShmFuzzLen + 4)
+	if !ok {
+		log.Println("Couldn't setup test case shared memory.")
+		return ok, pw
+	}
+	pw = shmIO{id: id, buf: buf, fallback: fallback}
+	return ok, pw
+}
+
 // ***********************
 // **** Shared Memory ****
 
-func setupShm() (ok bool, id uintptr, trace []byte) {
+func setupShm(size int) (ok bool, id uintptr, trace []byte) {
 	var err syscall.Errno
-	id, _, err = syscall.RawSyscall(syscall.SYS_SHMGET, ipcPrivate, mapSize,
-		ipcCreat|ipcExcl|0600)
+	id, _, err = syscall.RawSyscall(syscall.SYS_SHMGET, ipcPrivate,
+		uintptr(size), ipcCreat|ipcExcl|0600)
 	if err != 0 {
 		log.Printf("Problem creating a new shared memory segment: %v\n", err)
 		return
@@ -454,10 +728,9 @@ func setupShm() (ok bool, id uintptr, trace []byte) {
 	}
 
 	// Dirty thing we have to do (to use AFL instrumentation).
-	traceBitPt := (*[mapSize]byte)(unsafe.Pointer(segMap))
+	trace = unsafe.Slice((*byte)(unsafe.Pointer(segMap)), size)
 
 	ok = true
-	trace = (*traceBitPt)[:]
 	return ok, id, trace
 }
 
@@ -478,9 +751,16 @@ func zeroShm(traceBitPt []byte) {
 // ** Fork Server Initialization **
 // Binary launch specific to AFL.
 
-func initForkserver(binPath string, cliArgs []string, procAttr *syscall.ProcAttr) (
+// The fork server pipes are (briefly) inheritable in our process: nobody else
+// should start a process meanwhile (standalone runs), nor another fork server.
+var forksrvFdMtx sync.Mutex
+
+func forkForkserver(binPath string, cliArgs []string, procAttr *syscall.ProcAttr) (
 	ok bool, ctlPipeW *os.File, stPipeR *os.File, pid int) {
 
+	forksrvFdMtx.Lock()
+	defer forksrvFdMtx.Unlock()
+
 	// ** I - Pipe Management **
 	// AFL fork server pipes.
 	// The "control" pipe tell the fork server to prepare to receive an input.
@@ -498,6 +778,11 @@ func initForkserver(binPath string, cliArgs []string, procAttr *syscall.ProcAttr
 	}
 	//
 	ctlPipeR, stPipeW := ctlPipe[0], stPipe[1] // Just renaming.
+	// The status pipe is non-blocking, so reads from it can have a deadline.
+	if err := syscall.SetNonblock(stPipe[0], true); err != nil {
+		log.Printf("Couldn't make the status pipe non-blocking: %v.\n", err)
+		return
+	}
 	ctlPipeW = os.NewFile(uintptr(ctlPipe[1]), "|1")
 	stPipeR = os.NewFile(uintptr(stPipe[0]), "|0")
 	//
@@ -533,23 +818,38 @@ func initForkserver(binPath string, cliArgs []string, procAttr *syscall.ProcAttr
 	// ** II - (Finally) Fork **
 	var err error
 	execArgs := append([]string{binPath}, cliArgs...)
-	pid, err = syscall.ForkExec(binPath, execArgs, procAttr)
-	if err != nil {
-		log.Printf("Couldn't ForkExec %s: %v.\n", binPath, err)
-		return
-	}
+	withoutCoreDumps(func() {
+		pid, err = syscall.ForkExec(binPath, execArgs, procAttr)
+	})
 	//
 	// Fork epilogue: closing the pipes only the child is supposed to write into.
 	err1 = syscall.Close(forksrvFd)
 	err2 = syscall.Close(forksrvFd + 1)
-	if err1 != nil || err2 != nil {
+	if err != nil {
+		log.Printf("Couldn't ForkExec %s: %v.\n", binPath, err)
+		return
+	} else if err1 != nil || err2 != nil {
 		log.Printf("Error while closing the fork server (main process) pipes:"+
 			"(ctl) %v - (st) %v\n", err1, err2)
 	}
 
+	ok = true
+	return ok, ctlPipeW, stPipeR, pid
+}
+
+func initForkserver(binPath string, cliArgs []string, procAttr *syscall.ProcAttr) (
+	ok bool, ctlPipeW *os.File, stPipeR *os.File, pid int, opts forkserverOpts) {
+
+	okFork, ctlPipeW, stPipeR, pid := forkForkserver(binPath, cliArgs, procAttr)
+	if !okFork {
+		return ok, ctlPipeW, stPipeR, pid, opts
+	}
+
 	// ** III - Test **
 	// (Actually, this first handshake is needed to setup the fork server correctly.)
-	timer := time.NewTimer(time.Second)
+	// (Deferred fork servers may do a lot before saying hello.)
+	var err error
+	timer := time.NewTimer(forksrvInitTimeout)
 	encodedStatus := make([]byte, 4)
 	reportChan := make(chan error)
 	go func() { _, errR := stPipeR.Read(encodedStatus); reportChan <- errR }()
@@ -566,13 +866,125 @@ func initForkserver(binPath string, cliArgs []string, procAttr *syscall.ProcAttr
 	}
 
 	status := binary.LittleEndian.Uint32(encodedStatus)
-	if stat := syscall.WaitStatus(status); stat.Signaled() {
+	if status&fsOptError == fsOptError {
+		log.Printf("Fork server reported error %d.\n", (status&0x00ffff00)>>8)
+		return
+	} else if status&fsOptEnabled == fsOptEnabled {
+		opts = parseForkserverOpts(status)
+		ok = true
+	} else if stat := syscall.WaitStatus(status); stat.Signaled() {
 		fmt.Printf("stat.Signal() = %+v\n", stat.Signal())
 	} else {
 		ok = true
 	}
 
-	return ok, ctlPipeW, stPipeR, pid
+	return ok, ctlPipeW, stPipeR, pid, opts
+}
+
+// ************************************
+// ** AFL++ Fork Server Option Hello **
+// AFL++ fork servers advertise their options in the hello message.
+
+const (
+	fsOptEnabled        = 0x80000001
+	fsOptMapSize        = 0x40000000
+	fsOptSnapshot       = 0x20000000
+	fsOptAutodict       = 0x10000000
+	fsOptShdmemFuzz     = 0x01000000
+	fsOptOldAFLPPWorkar = 0x0f000000 // Workaround for old AFL++ versions.
+	fsOptError          = 0xf800008f
+)
+
+type forkserverOpts struct {
+	mapSize  int // 0 if not advertised.
+	shmFuzz  bool
+	autodict bool
+}
+
+func parseForkserverOpts(status uint32) (opts forkserverOpts) {
+	if status&fsOptOldAFLPPWorkar == fsOptOldAFLPPWorkar {
+		status &= 0xf0ffffff
+	}
+	if status&fsOptMapSize == fsOptMapSize {
+		opts.mapSize = int((status&0x00fffffe)>>1) + 1
+	}
+	opts.shmFuzz = status&fsOptShdmemFuzz == fsOptShdmemFuzz
+	opts.autodict = status&fsOptAutodict == fsOptAutodict
+	return opts
+}
+
+// The fork server waits for an answer only if it proposed shared memory
+// fuzzing or an auto dictionary.
+func replyForkserverOpts(ctlPipeW, stPipeR *os.File, opts forkserverOpts,
+	shmFuzz bool) (ok bool) {
+
+	if !opts.shmFuzz && !opts.autodict {
+		return true
+	}
+	var status uint32 = fsOptEnabled
+	if shmFuzz {
+		status |= fsOptShdmemFuzz
+	}
+	if opts.autodict {
+		status |= fsOptAutodict
+	}
+	encoded := make([]byte, 4)
+	binary.LittleEndian.PutUint32(encoded, status)
+	_, err := ctlPipeW.Write(encoded)
+	if err != nil {
+		log.Printf("Couldn't answer fork server options: %v.\n", err)
+		return ok
+	}
+	if !opts.autodict {
+		return true
+	}
+
+	// Auto dictionary: length, then the entries.
+	if _, err = io.ReadFull(stPipeR, encoded); err != nil {
+		log.Printf("Couldn't read auto dictionary length: %v.\n", err)
+		return ok
+	}
+	dictLen := binary.LittleEndian.Uint32(encoded)
+	if dictLen < 2 || dictLen > autodictMaxLen {
+		log.Printf("Invalid auto dictionary length: %d.\n", dictLen)
+		return ok
+	}
+	dictData := make([]byte, dictLen)
+	if _, err = io.ReadFull(stPipeR, dictData); err != nil {
+		log.Printf("Couldn't read auto dictionary: %v.\n", err)
+		return ok
+	}
+	addAutoTokens("autodict", func() [][]byte { return parseAutodict(dictData) })
+	return true
+}
+
+// **************
+// ** Map Size **
+// The first PUT started decides the map size everybody uses (fitness
+// functions, PCA, exports...).
+
+var (
+	mapSize      = defaultMapSize
+	mapSizeFixed bool
+	mapSizeMtx   sync.Mutex
+)
+
+func getMapSize() int {
+	mapSizeMtx.Lock()
+	defer mapSizeMtx.Unlock()
+	return mapSize
+}
+
+func fixMapSize(putSize int) int {
+	mapSizeMtx.Lock()
+	defer mapSizeMtx.Unlock()
+	if !mapSizeFixed {
+		if putSize > 0 { // Multiple of 64 (for hashing).
+			mapSize = ((putSize + 63) >> 6) << 6
+		}
+		mapSizeFixed = true
+	}
+	return mapSize
 }
 
 // ***************************
@@ -614,106 +1026,3 @@ func listChildren(pid int) (childrenPids []int) {
 
 	return childrenPids
 }
-
-// *****************************************************************************
-// *************************** CPU Affinity Managing ***************************
-
-var getCPUMtx sync.Mutex
-
-func lockRoutine() (bool, int) {
-	getCPUMtx.Lock()
-	defer getCPUMtx.Unlock()
-	unusedCPUs := getUnusedCPUs()
-
-	runtime.LockOSThread()
-
-	targetedCPU := -1
-	if len(unusedCPUs) > 0 {
-		for cpu, ok := range unusedCPUs {
-			if !ok {
-				continue
-			}
-			targetedCPU = cpu
-			break
-		}
-
-	} else { // No CPU available.
-		log.Print("No CPU available.")
-		return false, -1
-	}
-
-	var set unix.CPUSet
-	set.Zero()
-	set.Set(targetedCPU)
-
-	err := unix.SchedSetaffinity(0, &set)
-	if err != nil {
-		log.Printf("Could not associate PUT with a CPU: %v.\n", err)
-	}
-	return true, targetedCPU
-}
-
-func getUnusedCPUs() (unusedCPUs []bool) {
-	nbCPU := runtime.NumCPU()
-	//
-	unusedCPUs = make([]bool, nbCPU)
-	for cpu := range unusedCPUs {
-		unusedCPUs[cpu] = true
-	}
-	if deactivateHyperthread {
-		for cpu := range unusedCPUs {
-			if cpu%2 == 1 {
-				unusedCPUs[cpu] = false
-			}
-		}
-	}
-
-	procDir, err := ioutil.ReadDir("/proc")
-	if err != nil {
-		log.Printf("Could not read /proc: %v.\n", err)
-		return
-	}
-
-	for _, procFileInfo := range procDir {
-		if !procFileInfo.IsDir() { // Only care about dirs
-			continue
-		}
-		name := procFileInfo.Name()
-		if name[0] < '0' || name[0] > '9' { // Only care about pids
-			continue
-		}
-
-		pid, err := strconv.Atoi(name)
-		if err != nil {
-			continue
-		}
-
-		var set unix.CPUSet
-		set.Zero()
-		err = unix.SchedGetaffinity(pid, &set)
-		if err != nil {
-			continue
-		}
-
-		count := set.Count()
-		if count == nbCPU {
-			continue
-		}
-		status, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/status", pid))
-		if err != nil {
-			log.Printf("Cannot read %s status: %v.\n", name, err)
-			continue
-		}
-		if !strings.Contains(string(status), "VmSize") { // Prob' kernel task
-			continue
-		}
-
-		for cpu := range unusedCPUs {
-			if set.IsSet(cpu) {
-				unusedCPUs[cpu] = unusedCPUs[cpu] && false
-			}
-		}
-	}
-
-	return unusedCPUs
-}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// The test binary is also the simulated target (Hemipt simTargetCmd).
+func TestMain(m *testing.M) {
+	if len(os.Args) > 1 && os.Args[1] == simTargetCmd {
+		simTarget(os.Args[2:])
+		os.Exit(0)
+	} else if len(os.Args) > 1 && os.Args[1] == putExecCmd {
+		putExec(os.Args[2:])
+	}
+	os.Exit(m.Run())
+}
+
+func startSimPUT(tb testing.TB, simArgs ...string) *aflPutT {
+	exe, err := os.Executable()
+	if err != nil {
+		tb.Fatalf("Couldn't find test executable: %v.", err)
+	}
+	cliArgs := append([]string{simTargetCmd}, simArgs...)
+	put, ok := startAFLPUT(exe, cliArgs, putOptions{})
+	if !ok {
+		tb.Fatal("Couldn't start simulated PUT.")
+	}
+	return put
+}
+
+// Factory of simulated targets (the test executable is the simulated PUT).
+func simTargetFactory(tb testing.TB, backend string, opts putOptions,
+	simArgs ...string) targetFactory {
+
+	exe, err := os.Executable()
+	if err != nil {
+		tb.Fatalf("Couldn't find test executable: %v.", err)
+	}
+	cliArgs := append([]string{simTargetCmd}, simArgs...)
+	factory, ok := makeTargetFactory(backend, exe, cliArgs, opts)
+	if !ok {
+		tb.Fatal("Couldn't make target factory.")
+	}
+	return factory
+}
+
+func startSimTarget(tb testing.TB, backend string, opts putOptions,
+	simArgs ...string) *managedTarget {
+
+	factory := simTargetFactory(tb, backend, opts, simArgs...)
+	mt, ok := startManagedTarget(factory, time.Second)
+	if !ok {
+		tb.Fatal("Couldn't start simulated target.")
+	}
+	return mt
+}
+
+func TestForkserverRun(t *testing.T) {
+	tests := []struct {
+		name    string
+		simArgs []string
+	}{
+		{"stdin", nil},
+		{"file", []string{"@@"}},
+		{"persistent", []string{"-persistent"}},
+		{"persistent-file", []string{"-persistent", "@@"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			put := startSimPUT(t, test.simArgs...)
+			defer put.clean()
+			testSimRuns(t, put)
+		})
+	}
+}
+
+func testSimRuns(t *testing.T, put *aflPutT) {
+	run := func(input string, timeout time.Duration) runT {
+		runInfo, err := put.run([]byte(input), timeout)
+		if err != nil {
+			t.Fatalf("Run of %q failed: %v.", input, err)
+		}
+		return runInfo
+	}
+
+	ref := run("hello world", time.Second)
+	if ref.crashed || ref.hanged {
+		t.Fatalf("Normal input crashed (%v) or hanged (%v).", ref.crashed,
+			ref.hanged)
+	}
+	expected := make([]byte, len(put.trace))
+	simTrace([]byte("hello world"), expected, simMaxLen)
+	if !bytes.Equal(expected, put.trace) {
+		t.Error("Trace isn't the simulated one.")
+	}
+	if r := run("hello world", time.Second); r.hash != ref.hash {
+		t.Error("Same input, different trace hash.")
+	}
+	if r := run("bye world", time.Second); r.hash == ref.hash {
+		t.Error("Different inputs, same trace hash.")
+	}
+
+	if r := run("CRASH", time.Second); !r.crashed || r.sig != syscall.SIGABRT {
+		t.Errorf("Crash not detected: crashed=%v, sig=%v.", r.crashed, r.sig)
+	}
+	if r := run("HANG", 100*time.Millisecond); !r.hanged || r.crashed {
+		t.Errorf("Hang not detected: hanged=%v, crashed=%v.", r.hanged,
+			r.crashed)
+	}
+	// Still working after a crash and a hang.
+	if r := run("hello world", time.Second); r.hash != ref.hash {
+		t.Error("Trace changed after crash and hang.")
+	}
+}
+
+// Test cases delivered through shared memory, if the fork server offers it.
+func TestShmFuzz(t *testing.T) {
+	for _, simArgs := range [][]string{{"-shmfuzz"},
+		{"-shmfuzz", "-persistent"}} {
+
+		t.Run(strings.Join(simArgs, ""), func(t *testing.T) {
+			put := startSimPUT(t, simArgs...)
+			defer put.clean()
+			if _, isShm := put.writer.(shmIO); !isShm ||
+				!put.capabilities().shmFuzz {
+				t.Fatal("Shared memory test case delivery not used.")
+			}
+			testSimRuns(t, put)
+
+			// The recorded input is what the PUT got.
+			long := bytes.Repeat([]byte("a"), maxShmFuzzLen+1)
+			runInfo, err := put.run(long, time.Second)
+			if err != nil {
+				t.Fatalf("Run of a long test case failed: %v.", err)
+			} else if len(runInfo.input) != maxShmFuzzLen {
+				t.Errorf("Recorded input of %d bytes, delivered %d.",
+					len(runInfo.input), maxShmFuzzLen)
+			}
+		})
+	}
+
+	put := startSimPUT(t) // Not offered: file/stdin.
+	defer put.clean()
+	if _, isShm := put.writer.(shmIO); isShm {
+		t.Error("Shared memory delivery used without fork server option.")
+	}
+}
+
+// Compare the test case delivery methods (file/stdin vs. shared memory) on the
+// same PUT. The PUT needs an AFL++ instrumentation supporting shared memory
+// fuzzing and is given through the environment:
+//
+//	HEMIPT_BENCH_CLI="/path/to/put @@" go test -run XXX -bench PUTWriters
+func BenchmarkPUTWriters(b *testing.B) {
+	cliStr := os.Getenv("HEMIPT_BENCH_CLI")
+	if len(cliStr) == 0 {
+		b.Skip("HEMIPT_BENCH_CLI not set.")
+	}
+	putArgs := strings.Split(cliStr, " ")
+	binPath, cliArgs := putArgs[0], putArgs[1:]
+	testCase := []byte("Hemipt benchmark test case.")
+
+	for _, shmFuzz := range []bool{false, true} {
+		name := "file-stdin"
+		if shmFuzz {
+			name = "shm"
+		}
+		b.Run(name, func(b *testing.B) {
+			opts := putOptions{timeout: time.Second, noShmFuzz: !shmFuzz}
+			put, ok := startAFLPUT(binPath, cliArgs, opts)
+			if !ok {
+				b.Fatal("Couldn't start PUT.")
+			}
+			defer put.clean()
+			if _, isShm := put.writer.(shmIO); isShm != shmFuzz {
+				b.Skip("PUT doesn't support shared memory fuzzing.")
+			}
+
+			b.ResetTimer()
+			startT := time.Now()
+			for i := 0; i < b.N; i++ {
+				put.run(testCase, opts.timeout)
+			}
+			b.ReportMetric(float64(b.N)/time.Now().Sub(startT).Seconds(), "execs/s")
+		})
+	}
+}
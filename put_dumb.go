@@ -0,0 +1,76 @@
+package main
+
+import (
+	"log"
+
+	"math/bits"
+	"time"
+)
+
+// *****************************************************************************
+// ******************************* Dumb Mode ***********************************
+// For non-instrumented binaries: no fork server nor coverage, the PUT is
+// fork/exec-ed for each input (standalone runner). The trace is synthetic: one
+// "edge" per outcome feature (exit status/signal, hang, output length order of
+// magnitude), so that the scheduler, crash policy and exports still work.
+
+type dumbTarget struct {
+	trace []byte
+	sr    *standaloneRunner
+}
+
+func startDumbTarget(binPath string, cliArgs []string, opts putOptions) (
+	dt *dumbTarget, ok bool) {
+
+	sr, ok := newStandaloneRunner(binPath, cliArgs, opts)
+	if !ok {
+		log.Println("Couldn't setup dumb mode runner.")
+		return dt, ok
+	}
+	dt = &dumbTarget{trace: make([]byte, fixMapSize(0)), sr: sr}
+	return dt, ok
+}
+
+func (dt *dumbTarget) run(testCase []byte, timeout time.Duration) (
+	runInfo runT, err error) {
+
+	zeroShm(dt.trace)
+	res, err := dt.sr.run(testCase, timeout)
+	if err != nil {
+		return runInfo, err
+	}
+
+	runInfo.status, runInfo.execTime = res.status, res.execTime
+	runInfo.hanged, runInfo.crashed = res.hanged, res.crashed
+	if res.crashed && res.status.Signaled() {
+		runInfo.sig = res.status.Signal()
+	}
+	synthTrace(dt.trace, res)
+
+	runInfo.input = testCase
+	runInfo.hash = hashTrBits(dt.trace)
+	return runInfo, err
+}
+
+func (dt *dumbTarget) getTrace() []byte { return dt.trace }
+func (dt *dumbTarget) clean()           { dt.sr.clean() }
+func (dt *dumbTarget) capabilities() targetCaps {
+	return targetCaps{standalone: true}
+}
+
+func synthTrace(trace []byte, res standaloneRun) {
+	var hanged uint64
+	if res.hanged {
+		hanged = 1
+	}
+	outLen := len(res.stdout) + len(res.stderr)
+	feats := []uint64{
+		uint64(res.status),
+		hanged,
+		uint64(bits.Len(uint(outLen))),
+	}
+	for i, feat := range feats {
+		h := (feat+uint64(i)<<32)*0x9e3779b97f4a7c15 + uint64(i)
+		trace[(h>>32)%uint64(len(trace))] = 1
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Short campaign on the simulated target without instrumentation: the
+// synthetic trace is enough for the scheduler, crash policy and exports.
+func TestDumbFuzzLoop(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Fuzzing loop test is long.")
+	}
+	outDir := filepath.Join(t.TempDir(), "out")
+	createOutDir(outDir)
+	if !startCrashCollector(outDir, nil) || !startHangCollector(outDir) {
+		t.Fatal("Couldn't start crash/hang collectors.")
+	}
+	defer crashColl.stop()
+	defer hangColl.stop()
+
+	factory := simTargetFactory(t, dumbBackend, putOptions{})
+	threads, ok := startMultiThreads(1, factory, nil, calibTimeout)
+	if !ok {
+		t.Fatal("Couldn't start threads.")
+	}
+	defer threads[0].clean()
+
+	seedInputs := [][]byte{[]byte("hello dumb world"), []byte("CRASH")}
+	initSeeds := execInitSeed(threads, seedInputs)
+	if len(initSeeds) != len(seedInputs) {
+		t.Fatalf("Only %d/%d seeds executed.", len(initSeeds), len(seedInputs))
+	} else if initSeeds[0].hash == initSeeds[1].hash {
+		t.Error("Crashing and normal seeds have the same synthetic trace.")
+	}
+	calibrateTimeout(threads, initSeeds)
+
+	phase := phasePlan{Name: phaseFuzz, Kind: phaseFuzz, Rounds: 1,
+		Power: powerUniform, Fitness: []string{fitnessBrCov, fitnessPCA}}
+	execStartT := time.Now()
+	seeds := fuzzLoop(threads, initSeeds, phase)
+	if d := time.Since(execStartT); d > 2*roundTime*time.Duration(len(seeds)) {
+		t.Errorf("Fuzzing loop took %v for %d seeds.", d, len(seeds))
+	}
+	export(outDir, seeds)
+	saveSeeds(outDir, seeds)
+
+	crashColl.stop() // Saves the pending crashes.
+	if crashN, _ := crashColl.counts(); crashN == 0 {
+		t.Error("No crash collected.")
+	}
+	for _, dir := range []string{"crashes", "seeds"} {
+		infos, err := ioutil.ReadDir(filepath.Join(outDir, dir))
+		if err != nil {
+			t.Errorf("Couldn't read %s directory: %v.", dir, err)
+		} else if len(infos) == 0 {
+			t.Errorf("Nothing in %s directory.", dir)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"syscall"
+	"time"
+)
+
+// *****************************************************************************
+// ***************************** Go Harnesses **********************************
+// In-process target: a Go function executed on the test case, writing its
+// coverage (AFL-like hit counts) in the trace. Selected by name (-cli). A
+// panic is a crash. A hanging harness can't be stopped: its goroutine is left
+// behind (with its own trace), so harnesses should not hang.
+
+type goHarness func(input, trace []byte)
+
+var goHarnesses = map[string]goHarness{
+	"sim": simHarness,
+}
+
+type goTarget struct {
+	harness goHarness
+	trace   []byte
+}
+
+func startGoTarget(harness goHarness) (gt *goTarget, ok bool) {
+	gt = &goTarget{harness: harness, trace: make([]byte, fixMapSize(0))}
+	ok = true
+	return gt, ok
+}
+
+func (gt *goTarget) run(testCase []byte, timeout time.Duration) (
+	runInfo runT, err error) {
+
+	zeroShm(gt.trace)
+	trace := gt.trace
+	doneChan := make(chan interface{}, 1)
+	timer := time.NewTimer(timeout)
+	execStartT := time.Now()
+	go func() {
+		defer func() { doneChan <- recover() }()
+		gt.harness(testCase, trace)
+	}()
+
+	select {
+	case r := <-doneChan:
+		timer.Stop()
+		if r != nil {
+			runInfo.crashed, runInfo.sig = true, syscall.SIGABRT
+			runInfo.status = syscall.WaitStatus(syscall.SIGABRT)
+		}
+	case <-timer.C:
+		runInfo.hanged = true
+		gt.trace = make([]byte, len(trace))
+	}
+	runInfo.execTime = time.Now().Sub(execStartT)
+
+	runInfo.input = testCase
+	runInfo.hash = hashTrBits(gt.trace)
+	return runInfo, err
+}
+
+func (gt *goTarget) getTrace() []byte { return gt.trace }
+func (gt *goTarget) clean()           {}
+func (gt *goTarget) capabilities() targetCaps {
+	return targetCaps{coverage: true}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"log"
+
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// *****************************************************************************
+// **************************** Standalone Runner ******************************
+// Runs the PUT once per test case, without fork server nor coverage shared
+// memory, and with its output captured. Slow, but the PUT is "on its own" (e.g.
+// to get a sanitizer report).
+
+const (
+	maxCaptureLen = 1 << 20 // Of the stdout/stderr kept.
+	// Once the PUT exited, how long its children may keep its output open.
+	captureWaitDelay = 100 * time.Millisecond
+)
+
+type standaloneRunner struct {
+	binPath string
+	cliArgs []string // With the test case path, if file input.
+	fileIn  bool
+	tcPath  string
+	env     []string
+	opts    putOptions
+
+	launchPath string
+	launchArgs []string
+	sbxDir     string
+
+	usesMsan bool
+}
+
+type standaloneRun struct {
+	status   syscall.WaitStatus
+	crashed  bool
+	hanged   bool
+	execTime time.Duration
+
+	stdout, stderr []byte
+}
+
+func newStandaloneRunner(binPath string, cliArgs []string, opts putOptions) (
+	sr *standaloneRunner, ok bool) {
+
+	binContent, err := ioutil.ReadFile(binPath)
+	if err != nil {
+		log.Printf("Couldn't open the binary: %v.\n", err)
+		return sr, ok
+	}
+
+	sr = &standaloneRunner{binPath: binPath, opts: opts}
+	sr.tcPath = filepath.Join(workDir, tmpName("tmp-sa"))
+	fileIn, args, fileArg, filePathPos := parseArgs(cliArgs)
+	if fileIn {
+		setFileArg(args, fileArg, filePathPos, sr.tcPath)
+	}
+	sr.fileIn, sr.cliArgs = fileIn, args
+	//
+	sanEnvs, usesMsan := getSanitizerEnvs(binContent)
+	sr.env = append(os.Environ(), sanEnvs...)
+	sr.usesMsan = usesMsan
+
+	ok, sr.launchPath, sr.launchArgs, sr.sbxDir = wrapPUTLaunch(
+		binPath, sr.cliArgs, opts)
+	if !ok {
+		log.Println("Couldn't prepare PUT limits/sandbox.")
+	}
+	return sr, ok
+}
+
+func (sr *standaloneRunner) run(testCase []byte, timeout time.Duration) (
+	res standaloneRun, err error) {
+
+	err = ioutil.WriteFile(sr.tcPath, testCase, 0600)
+	if err != nil {
+		log.Printf("Could not write test case file %s: %v.\n", sr.tcPath, err)
+		return res, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, sr.launchPath, sr.launchArgs...)
+	cmd.Env = sr.env
+	cmd.WaitDelay = captureWaitDelay
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if sr.opts.sandbox {
+		sandboxSysProcAttr(cmd.SysProcAttr)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if !sr.fileIn {
+		stdin, errO := os.Open(sr.tcPath)
+		if errO != nil {
+			log.Printf("Could not open test case file %s: %v.\n", sr.tcPath, errO)
+			return res, errO
+		}
+		defer stdin.Close()
+		cmd.Stdin = stdin
+	}
+
+	execStartT := time.Now()
+	forksrvFdMtx.Lock() // Don't inherit fork server pipes.
+	withoutCoreDumps(func() { err = cmd.Start() })
+	forksrvFdMtx.Unlock()
+	if err != nil { // No process state (e.g. the deadline already passed).
+		log.Printf("Could not start %s: %v.\n", sr.binPath, err)
+		return res, err
+	}
+	err = cmd.Wait()
+	res.execTime = time.Now().Sub(execStartT)
+	res.hanged = ctx.Err() == context.DeadlineExceeded
+	if _, isExit := err.(*exec.ExitError); isExit || res.hanged ||
+		errors.Is(err, exec.ErrWaitDelay) {
+		err = nil // PUT "failures" are what we are after.
+	} else if err != nil {
+		log.Printf("Could not run %s: %v.\n", sr.binPath, err)
+		return res, err
+	}
+
+	res.status = cmd.ProcessState.Sys().(syscall.WaitStatus)
+	if res.hanged {
+		// Killed by us, so not a crash.
+	} else if res.status.Signaled() {
+		res.crashed = true
+	} else if sr.usesMsan && res.status.ExitStatus() == msanError {
+		res.crashed = true
+	}
+	res.stdout = truncCapture(stdout.Bytes())
+	res.stderr = truncCapture(stderr.Bytes())
+	return res, err
+}
+
+func truncCapture(out []byte) []byte {
+	if len(out) > maxCaptureLen {
+		out = out[:maxCaptureLen]
+	}
+	return out
+}
+
+func (sr *standaloneRunner) clean() {
+	os.Remove(sr.tcPath)
+	if len(sr.sbxDir) > 0 {
+		os.Remove(sr.sbxDir)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStandaloneRun(t *testing.T) {
+	sr, ok := newStandaloneRunner("/bin/sh", []string{"-c",
+		"(sleep 5) & echo started"}, putOptions{})
+	if !ok {
+		t.Fatal("Couldn't make standalone runner.")
+	}
+	defer sr.clean()
+
+	// A child of the PUT keeps its output open: not waited for.
+	execStartT := time.Now()
+	res, err := sr.run(nil, 10*time.Second)
+	if err != nil {
+		t.Fatalf("Couldn't run the PUT: %v.", err)
+	} else if d := time.Since(execStartT); d > time.Second {
+		t.Errorf("Run took %v: the PUT child was waited for.", d)
+	}
+	if string(res.stdout) != "started\n" || res.hanged || res.crashed {
+		t.Errorf("Wrong run: %+v.", res)
+	}
+
+	// Deadline passed before the start: an error, not a panic.
+	if _, err := sr.run(nil, 0); err == nil {
+		t.Error("PUT ran after its deadline.")
+	}
+}
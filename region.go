@@ -22,8 +22,11 @@ func makeRegion(proj []float64) regionT {
 	return r
 }
 
-func findRegion(regions []regionT, pt []float64, hash uint64) (closestRI int) {
-	minDist := math.MaxFloat64
+// Squared distance.
+func closestRegion(regions []regionT, pt []float64) (closestRI int,
+	minDist float64) {
+
+	minDist = math.MaxFloat64
 	for i, r := range regions {
 		var dist float64
 		for j, p := range r.proj {
@@ -35,6 +38,11 @@ func findRegion(regions []regionT, pt []float64, hash uint64) (closestRI int) {
 			closestRI = i
 		}
 	}
+	return closestRI, minDist
+}
+
+func findRegion(regions []regionT, pt []float64, hash uint64) (closestRI int) {
+	closestRI, minDist := closestRegion(regions, pt)
 
 	regions[closestRI].sampleN++
 	if _, ok := regions[closestRI].speciesMap[hash]; !ok {
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"flag"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// *****************************************************************************
+// ************************** Random Number Seeds ******************************
+// The campaign seed (-seed, or the clock) seeds math/rand. Each fuzzed seed's
+// mutator gets its own RNG, whose seed is derived from the campaign seed and
+// the seed hash: it doesn't depend on thread scheduling, and is recorded in the
+// seed metadata so its test case sequence can be replayed. A later phase which
+// rebuilds the mutator (phasePlan.Mutator) derives another RNG seed from it
+// and the phase index, also in the metadata: it doesn't replay the test cases
+// of the earlier phases.
+
+var campaignSeed int64
+
+func setCampaignSeed(seed int64) {
+	if seed == 0 {
+		seed = time.Now().UTC().UnixNano()
+	}
+	campaignSeed = seed
+	rand.Seed(seed)
+	fmt.Printf("Random seed: %d\n", seed)
+}
+
+func mutatorSeed(seedHash uint64) int64 {
+	return deriveSeed(campaignSeed, seedHash)
+}
+
+// The salt is out of the range of the other derivations (splice, mixed...).
+func phaseSeed(rngSeed int64, phaseI int) int64 {
+	if phaseI == 0 {
+		return rngSeed
+	}
+	return deriveSeed(rngSeed, uint64(phaseI)<<32)
+}
+
+// Campaigns can share a seed: temporary files also get the process ID.
+func tmpName(prefix string) string {
+	return fmt.Sprintf("%s-%d-%x", prefix, os.Getpid(), rand.Int63())
+}
+
+// SplitMix64 finalizer of the combined values.
+func deriveSeed(seed int64, salt uint64) int64 {
+	z := uint64(seed) ^ (salt * 0x9e3779b97f4a7c15)
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return int64(z ^ (z >> 31))
+}
+
+func seedMeta(seed *seedT) []string {
+	return []string{
+		fmt.Sprintf("hash: 0x%x", seed.hash),
+		fmt.Sprintf("campaign_seed: %d", campaignSeed),
+		fmt.Sprintf("rng_seed: %d", seed.rngSeed),
+		fmt.Sprintf("phase: %d", seed.mutPhase),
+		fmt.Sprintf("mutator: %s", seed.mutOpts.kind),
+		fmt.Sprintf("stack: %d", seed.mutOpts.stackMax),
+		fmt.Sprintf("dict_n: %d", len(seed.mutOpts.dict)),
+		fmt.Sprintf("splice: %s", seed.mutOpts.splice),
+		fmt.Sprintf("exec_n: %d", seed.execN),
+	}
+}
+
+func parseMeta(path string) (meta map[string]string, ok bool) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Printf("Couldn't read metadata: %v.\n", err)
+		return meta, ok
+	}
+	meta = make(map[string]string)
+	for _, line := range strings.Split(string(content), "\n") {
+		i := strings.Index(line, ": ")
+		if i < 0 {
+			continue
+		}
+		meta[line[:i]] = line[i+2:]
+	}
+	ok = true
+	return meta, ok
+}
+
+// *****************************************************************************
+// ********************************* Replay ************************************
+// "hemipt replay": regenerate the first test cases the mutator of a seed
+// generated, from the seed and its metadata (<seed>.meta in the seeds
+// directory). Splicing partners and input-to-state mutations depend on the
+// rest of the campaign: they are not replayed.
+
+const replayCmd = "replay"
+
+func replay(args []string) {
+	fs := flag.NewFlagSet(replayCmd, flag.ExitOnError)
+	seedPath := fs.String("i", "", "Seed (its metadata is <seed>.meta)")
+	outDir := fs.String("o", "", "Directory of the regenerated test cases")
+	n := fs.Int("n", 1000, "Number of test cases to regenerate")
+	dictPath := fs.String("x", "", "Dictionary of the campaign (dictionary "+
+		"directory of its output)")
+	fs.Parse(args)
+	if len(*seedPath) == 0 || len(*outDir) == 0 {
+		fs.Usage()
+		log.Fatal("Please provide seed and output paths.")
+	}
+
+	seedIn, err := ioutil.ReadFile(*seedPath)
+	if err != nil {
+		log.Fatalf("Couldn't read seed: %v.\n", err)
+	}
+	meta, ok := parseMeta(*seedPath + ".meta")
+	if !ok {
+		log.Fatal("Seed metadata is needed to replay.")
+	}
+	var dict [][]byte
+	if len(*dictPath) > 0 {
+		if dict, ok = loadDict(*dictPath); !ok {
+			log.Fatal("Couldn't load the dictionary.")
+		}
+	}
+	ig, ok := replayMutator(seedIn, meta, dedupTokens(dict))
+	if !ok {
+		log.Fatal("Couldn't rebuild the mutator of the seed.")
+	}
+
+	createOutDir(*outDir)
+	for i := 0; i < *n; i++ {
+		path := filepath.Join(*outDir, fmt.Sprintf("%06d", i))
+		err := ioutil.WriteFile(path, ig.generate(), 0644)
+		if err != nil {
+			log.Printf("Couldn't write test case %d: %v.\n", i, err)
+			return
+		}
+	}
+	fmt.Printf("Regenerated %d test cases in %s.\n", *n, *outDir)
+}
+
+func replayMutator(seedIn []byte, meta map[string]string, dict [][]byte) (
+	ig inputGen, ok bool) {
+
+	rngSeed, err := strconv.ParseInt(meta["rng_seed"], 10, 64)
+	if err != nil {
+		log.Printf("Invalid RNG seed: %v.\n", err)
+		return ig, ok
+	}
+	var phaseI int // Metadata without phase: first phase.
+	if len(meta["phase"]) > 0 {
+		if phaseI, err = strconv.Atoi(meta["phase"]); err != nil {
+			log.Printf("Invalid phase: %v.\n", err)
+			return ig, ok
+		}
+	}
+	opts := mutatorOptions{kind: meta["mutator"], dict: dict}
+	if opts.kind == havocMutKind {
+		if opts.stackMax, err = strconv.Atoi(meta["stack"]); err != nil {
+			log.Printf("Invalid havoc stack: %v.\n", err)
+			return ig, ok
+		}
+		if dictN, _ := strconv.Atoi(meta["dict_n"]); dictN != len(dict) {
+			log.Printf("Warning: the campaign dictionary had %d tokens, "+
+				"%d given: test cases will differ.\n", dictN, len(dict))
+		}
+	}
+	if len(meta["splice"]) > 0 {
+		log.Println("Warning: splicing isn't replayed, test cases will " +
+			"differ.")
+	}
+
+	ig, ok = buildMutator(seedIn, 0, phaseSeed(rngSeed, phaseI), opts), true
+	return ig, ok
+}
+
+// Tokens are saved one per file (dictionary directory format) and in order.
+func saveDict(outDir string, dict [][]byte) {
+	if len(dict) == 0 {
+		return
+	}
+	dir := filepath.Join(outDir, "dictionary")
+	err := os.Mkdir(dir, 0755)
+	if err != nil {
+		log.Printf("Couldn't create dictionary directory: %v.\n", err)
+		return
+	}
+	for i, tok := range dict {
+		path := filepath.Join(dir, fmt.Sprintf("token-%06d", i))
+		err := ioutil.WriteFile(path, tok, 0644)
+		if err != nil {
+			log.Printf("Couldn't write token %d: %v.\n", i, err)
+			return
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+// The test cases of a seed mutator are regenerated from its metadata.
+func TestReplayMutator(t *testing.T) {
+	defer func(conf mutatorOptions, seed int64) {
+		mutConf, campaignSeed = conf, seed
+	}(mutConf, campaignSeed)
+	campaignSeed = 42
+	if mutatorSeed(1) != mutatorSeed(1) || mutatorSeed(1) == mutatorSeed(2) {
+		t.Fatal("Mutator seeds aren't derived from the seed hashes.")
+	}
+
+	seedIn := []byte("The quick brown fox jumps over the lazy dog.")
+	dict := [][]byte{[]byte("KEYWORD"), []byte("\x00\xff")}
+	// Then, the mutator is rebuilt by a later phase, from another RNG seed.
+	firstTCs := make(map[string][]byte)
+	for _, test := range []struct {
+		phaseI int
+		kind   string
+	}{{0, ratioMutKind}, {0, havocMutKind}, {2, ratioMutKind},
+		{2, havocMutKind}} {
+
+		kind := test.kind
+		mutConf = mutatorOptions{kind: kind, stackMax: 8, dict: dict}
+		seed := &seedT{runT: runT{input: seedIn, hash: 1}}
+		seed.rngSeed = mutatorSeed(seed.hash)
+		sched := &scheduler{phase: phasePlan{index: test.phaseI}}
+		ig := sched.makeMutator(seed)
+
+		dir := t.TempDir()
+		path := filepath.Join(dir, "seed.meta")
+		saveLines(path, seedMeta(seed))
+		saveDict(dir, dict)
+		meta, ok := parseMeta(path)
+		if !ok {
+			t.Fatal("Couldn't parse seed metadata.")
+		}
+		loaded, ok := loadDict(filepath.Join(dir, "dictionary"))
+		if !ok {
+			t.Fatal("Couldn't load saved dictionary.")
+		}
+		replayed, ok := replayMutator(seedIn, meta, loaded)
+		if !ok {
+			t.Fatalf("Couldn't rebuild the %s mutator.", kind)
+		}
+
+		for i := 0; i < 100; i++ {
+			tc1, tc2 := ig.generate(), replayed.generate()
+			if !bytes.Equal(tc1, tc2) {
+				t.Fatalf("Phase %d %s test case %d differs: %q != %q.",
+					test.phaseI, kind, i, tc1, tc2)
+			}
+			if i > 0 {
+				continue
+			} else if test.phaseI == 0 {
+				firstTCs[kind] = tc1
+			} else if bytes.Equal(tc1, firstTCs[kind]) {
+				t.Errorf("Phase %d %s mutator replays the first phase.",
+					test.phaseI, kind)
+			}
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// *****************************************************************************
+// ************************** PUT Limits & Sandbox *****************************
+// Resource limits can't be set on the fuzzer itself (a memory limit would
+// apply to Hemipt too), and ForkExec gives no hook between fork and exec. So,
+// when needed, the fork server is launched through Hemipt itself (putExecCmd
+// sub-command) which sets the limits, the sandbox working directory, and then
+// execve the PUT. The pid (and fork server pipes) stay the same.
+// The sandbox makes the whole file system read-only but for a private tmpfs
+// working directory. The PUT is root in its user namespace (mapped to the
+// caller, so the launcher can mount), but without capabilities: it can't undo
+// the mounts.
+
+const (
+	putExecCmd = "putexec"
+
+	sbxTmpfsOpts = "size=64m,mode=0700"
+
+	// Root of the user namespace gets no capabilities from execve.
+	secbitNoRoot       = 1 << 0
+	secbitNoRootLocked = 1 << 1
+)
+
+// Core dumps are slow and useless to us: disabled for the PUTs only. Their
+// soft limit is lowered while they're started (inherited), with forksrvFdMtx
+// locked: no other process starts meanwhile.
+func withoutCoreDumps(start func()) {
+	var lim unix.Rlimit
+	err := unix.Getrlimit(unix.RLIMIT_CORE, &lim)
+	if err == nil {
+		noCore := lim
+		noCore.Cur = 0
+		err = unix.Setrlimit(unix.RLIMIT_CORE, &noCore)
+	}
+	if err != nil {
+		log.Printf("Couldn't disable core dumps: %v.\n", err)
+		start()
+		return
+	}
+	start()
+	unix.Setrlimit(unix.RLIMIT_CORE, &lim)
+}
+
+func (opts putOptions) needsPUTExec() bool {
+	return opts.memLimit > 0 || opts.fsizeLimit > 0 || opts.sandbox
+}
+
+// Returns what to actually ForkExec to launch the PUT. If a sandbox directory
+// is created (sbxDir), it has to be removed when the PUT is cleaned.
+func wrapPUTLaunch(binPath string, cliArgs []string, opts putOptions) (
+	ok bool, launchPath string, launchArgs []string, sbxDir string) {
+
+	if !opts.needsPUTExec() {
+		return true, binPath, cliArgs, sbxDir
+	}
+
+	launchPath, err := os.Executable()
+	if err != nil {
+		log.Printf("Couldn't find Hemipt executable: %v.\n", err)
+		return ok, launchPath, launchArgs, sbxDir
+	}
+	launchArgs = []string{putExecCmd,
+		fmt.Sprintf("-as=%d", opts.memLimit),
+		fmt.Sprintf("-fsize=%d", opts.fsizeLimit),
+	}
+	if opts.sandbox {
+		sbxDir = filepath.Join(workDir, tmpName("hemipt-sbx"))
+		err = os.Mkdir(sbxDir, 0700)
+		if err != nil {
+			log.Printf("Couldn't create sandbox directory: %v.\n", err)
+			return ok, launchPath, launchArgs, ""
+		}
+		launchArgs = append(launchArgs, "-tmpfs="+sbxDir)
+	}
+	launchArgs = append(launchArgs, "--", binPath)
+	launchArgs = append(launchArgs, cliArgs...)
+
+	ok = true
+	return ok, launchPath, launchArgs, sbxDir
+}
+
+// Fresh user namespace (so it works unprivileged), mount namespace (for the
+// private tmpfs) and network namespace (no network but a loopback down).
+func sandboxSysProcAttr(sysAttr *syscall.SysProcAttr) {
+	sysAttr.Cloneflags = syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS |
+		syscall.CLONE_NEWNET
+	sysAttr.UidMappings = []syscall.SysProcIDMap{
+		{ContainerID: 0, HostID: os.Getuid(), Size: 1},
+	}
+	sysAttr.GidMappings = []syscall.SysProcIDMap{
+		{ContainerID: 0, HostID: os.Getgid(), Size: 1},
+	}
+	sysAttr.GidMappingsEnableSetgroups = false
+}
+
+// Entry point of the putExecCmd sub-command. Any error is fatal: the fork
+// server handshake fails on the other end.
+func putExec(args []string) {
+	fs := flag.NewFlagSet(putExecCmd, flag.ExitOnError)
+	memLimit := fs.Uint64("as", 0, "Address space limit in MB (0: none)")
+	fsizeLimit := fs.Uint64("fsize", 0, "File size limit in MB (0: none)")
+	tmpfsDir := fs.String("tmpfs", "", "Private tmpfs working directory")
+	fs.Parse(args)
+	putArgs := fs.Args()
+	if len(putArgs) == 0 {
+		log.Fatal("No PUT given to execute.")
+	}
+
+	// ** I - Working directory **
+	if len(*tmpfsDir) > 0 {
+		// Don't propagate our mounts back to the parent namespace.
+		err := unix.Mount("none", "/", "", unix.MS_REC|unix.MS_PRIVATE, "")
+		if err != nil {
+			log.Fatalf("Couldn't make mounts private: %v.\n", err)
+		}
+		remountReadOnly()
+		err = unix.Mount("tmpfs", *tmpfsDir, "tmpfs",
+			unix.MS_NOSUID|unix.MS_NODEV, sbxTmpfsOpts)
+		if err != nil {
+			log.Fatalf("Couldn't mount sandbox tmpfs: %v.\n", err)
+		}
+		err = os.Chdir(*tmpfsDir)
+		if err != nil {
+			log.Fatalf("Couldn't move to sandbox directory: %v.\n", err)
+		}
+		err = unix.Prctl(unix.PR_SET_SECUREBITS,
+			secbitNoRoot|secbitNoRootLocked, 0, 0, 0)
+		if err != nil {
+			log.Fatalf("Couldn't drop the PUT capabilities: %v.\n", err)
+		}
+	}
+
+	// ** II - Limits **
+	// Last thing before exec: the Go runtime itself might not like them.
+	if *fsizeLimit > 0 {
+		setRlimit(unix.RLIMIT_FSIZE, *fsizeLimit<<20)
+	}
+	if *memLimit > 0 {
+		setRlimit(unix.RLIMIT_AS, *memLimit<<20)
+	}
+
+	// ** III - Execute **
+	err := syscall.Exec(putArgs[0], putArgs, os.Environ())
+	log.Fatalf("Couldn't execute %s: %v.\n", putArgs[0], err)
+}
+
+// Every mount point of the (private) mount namespace. Remounts must keep the
+// flags locked by the parent namespace (nosuid, nodev...).
+func remountReadOnly() {
+	content, err := ioutil.ReadFile("/proc/self/mountinfo")
+	if err != nil {
+		log.Fatalf("Couldn't read mount points: %v.\n", err)
+	}
+	const keptFlags = unix.MS_NOSUID | unix.MS_NODEV | unix.MS_NOEXEC |
+		unix.MS_NOATIME | unix.MS_NODIRATIME | unix.MS_RELATIME
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 5 {
+			continue
+		}
+		mountPoint := unescapeMountPath(fields[4])
+		var st unix.Statfs_t
+		if err := unix.Statfs(mountPoint, &st); err != nil {
+			continue // Shadowed, or not accessible from here.
+		}
+		flags := uintptr(st.Flags)&keptFlags |
+			unix.MS_REMOUNT | unix.MS_BIND | unix.MS_RDONLY
+		err := unix.Mount("none", mountPoint, "", flags, "")
+		if err != nil {
+			log.Fatalf("Couldn't remount %s read-only: %v.\n", mountPoint,
+				err)
+		}
+	}
+}
+
+// Spaces and the like are octal escapes (\040) in mountinfo.
+func unescapeMountPath(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		if path[i] == '\\' && i+3 < len(path) {
+			if c, err := strconv.ParseUint(path[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(path[i])
+	}
+	return b.String()
+}
+
+func setRlimit(resource int, limit uint64) {
+	lim := &unix.Rlimit{Cur: limit, Max: limit}
+	err := unix.Setrlimit(resource, lim)
+	if err != nil {
+		log.Fatalf("Couldn't set resource limit %d: %v.\n", resource, err)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Hemipt keeps its core dump limit; the PUT can't dump core, nor write
+// outside its working directory, nor undo the sandbox.
+func TestPUTSandbox(t *testing.T) {
+	var lim unix.Rlimit
+	if err := unix.Getrlimit(unix.RLIMIT_CORE, &lim); err != nil {
+		t.Fatalf("Couldn't get core dump limit: %v.", err)
+	}
+	outside := filepath.Join(t.TempDir(), "outside")
+	script := strings.Join([]string{
+		"ulimit -S -c", "ulimit -S -f",
+		"echo x > " + outside + " || echo readonly",
+		"echo x > inside && echo tmpfs",
+		"mount -o remount,rw,bind / 2>/dev/null || echo locked",
+	}, "; ")
+	opts := putOptions{fsizeLimit: 1, sandbox: true}
+	sr, ok := newStandaloneRunner("/bin/sh", []string{"-c", script}, opts)
+	if !ok {
+		t.Fatal("Couldn't make standalone runner.")
+	}
+	defer sr.clean()
+
+	res, err := sr.run(nil, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Couldn't run the PUT: %v.", err)
+	}
+	out := strings.Fields(string(res.stdout))
+	expected := []string{"0", "2048", "readonly", "tmpfs", "locked"}
+	if strings.Join(out, " ") != strings.Join(expected, " ") {
+		t.Errorf("PUT output %q, expected %q (stderr: %s).", out, expected,
+			res.stderr)
+	}
+	if _, err := os.Stat(outside); err == nil {
+		t.Error("PUT wrote outside of the sandbox.")
+	}
+
+	var after unix.Rlimit
+	if err := unix.Getrlimit(unix.RLIMIT_CORE, &after); err != nil ||
+		after != lim {
+		t.Errorf("Core dump limit changed from %+v to %+v.", lim, after)
+	}
+}
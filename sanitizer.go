@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// *****************************************************************************
+// **************************** Sanitizer Reports ******************************
+// Parse the ASAN/MSAN/UBSAN report a crashing PUT wrote on stderr. Reports are
+// not symbolized (symbolize=0): frames are identified by module and offset,
+// which are stable between runs, even with ASLR.
+
+const reportKeyFrameN = 3 // Number of top frames in the deduplication key.
+
+type sanReport struct {
+	sanitizer string
+	bugType   string
+	pc        uint64
+	location  string   // UBSAN only: "file:line:column".
+	frames    []string // "module+0xoffset" (or address if no module).
+}
+
+var (
+	reSanHeader = regexp.MustCompile(
+		`(?m)(?:ERROR|WARNING): (\w+Sanitizer): ([\w-]+)(.*)$`)
+	reSanPC    = regexp.MustCompile(`\bpc 0x([0-9a-fA-F]+)`)
+	reSanFrame = regexp.MustCompile(`(?m)^\s*#(\d+) 0x([0-9a-fA-F]+)(.*)$`)
+	reSanMod   = regexp.MustCompile(`\(([^()\s]+)\+0x([0-9a-fA-F]+)\)`)
+	reUBSan    = regexp.MustCompile(`(?m)^(\S+:\d+:\d+): runtime error: (.*)$`)
+	reNumbers  = regexp.MustCompile(`\d+`)
+)
+
+func parseSanReport(stderr []byte) (rep sanReport, ok bool) {
+	if m := reSanHeader.FindSubmatch(stderr); m != nil {
+		rep.sanitizer, rep.bugType = string(m[1]), string(m[2])
+		if pcM := reSanPC.FindSubmatch(m[3]); pcM != nil {
+			rep.pc, _ = strconv.ParseUint(string(pcM[1]), 16, 64)
+		}
+	} else if m := reUBSan.FindSubmatch(stderr); m != nil {
+		rep.sanitizer = "UndefinedBehaviorSanitizer"
+		rep.location = string(m[1])
+		// E.g. "index 7 out of bounds for type 'int [4]'": drop the values.
+		msg := strings.SplitN(string(m[2]), ":", 2)[0]
+		rep.bugType = reNumbers.ReplaceAllString(msg, "N")
+	} else {
+		return rep, ok
+	}
+
+	// First stack trace only.
+	for _, m := range reSanFrame.FindAllSubmatch(stderr, -1) {
+		if string(m[1]) == "0" && len(rep.frames) > 0 {
+			break
+		}
+		addr, _ := strconv.ParseUint(string(m[2]), 16, 64)
+		if len(rep.frames) == 0 && rep.pc == 0 {
+			rep.pc = addr
+		}
+		if modM := reSanMod.FindSubmatch(m[3]); modM != nil {
+			rep.frames = append(rep.frames, fmt.Sprintf("%s+0x%s",
+				filepath.Base(string(modM[1])), modM[2]))
+		} else {
+			rep.frames = append(rep.frames, fmt.Sprintf("0x%x", addr))
+		}
+	}
+
+	ok = true
+	return rep, ok
+}
+
+func (rep sanReport) key() string {
+	frames := rep.frames
+	if len(frames) > reportKeyFrameN {
+		frames = frames[:reportKeyFrameN]
+	}
+	return fmt.Sprintf("%s/%s/%s/%s", rep.sanitizer, rep.bugType, rep.location,
+		strings.Join(frames, ";"))
+}
+
+func (rep sanReport) lines() (lines []string) {
+	lines = []string{
+		fmt.Sprintf("sanitizer: %s", rep.sanitizer),
+		fmt.Sprintf("bug_type: %s", rep.bugType),
+		fmt.Sprintf("pc: 0x%x", rep.pc),
+	}
+	if len(rep.location) > 0 {
+		lines = append(lines, fmt.Sprintf("location: %s", rep.location))
+	}
+	for i, frame := range rep.frames {
+		lines = append(lines, fmt.Sprintf("frame_%d: %s", i, frame))
+	}
+	lines = append(lines, fmt.Sprintf("key: %s", rep.key()))
+	return lines
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const asanReport = `=================================================================
+==4321==ERROR: AddressSanitizer: heap-buffer-overflow on address 0x602000000011 at pc 0x55d4c7a1b2c3 bp 0x7ffd5e3c1a10 sp 0x7ffd5e3c1a08
+READ of size 1 at 0x602000000011 thread T0
+    #0 0x55d4c7a1b2c2 in parse /src/target.c:12:10 (/tmp/target+0x1b2c2)
+    #1 0x55d4c7a1b3f0 in main /src/target.c:30:3 (/tmp/target+0x1b3f0)
+    #2 0x7f3a9c029d8f in __libc_start_call_main (/lib/x86_64-linux-gnu/libc.so.6+0x29d8f)
+    #3 0x55d4c7a1a0e4 in _start (/tmp/target+0x1a0e4)
+
+0x602000000011 is located 0 bytes to the right of 1-byte region [0x602000000010,0x602000000011)
+allocated by thread T0 here:
+    #0 0x7f3a9c4b4887 in __interceptor_malloc (/lib/x86_64-linux-gnu/libasan.so.6+0xb4887)
+    #1 0x55d4c7a1b37d in main /src/target.c:25:15 (/tmp/target+0x1b37d)
+
+SUMMARY: AddressSanitizer: heap-buffer-overflow /src/target.c:12:10 in parse
+==4321==ABORTING
+`
+
+const msanReport = `==8765==WARNING: MemorySanitizer: use-of-uninitialized-value
+    #0 0x5611a2b4c9e1 in check /src/target.c:18:7 (/tmp/target+0xc9e1)
+    #1 0x5611a2b4ca55 in main /src/target.c:40:5 (/tmp/target+0xca55)
+    #2 0x7f02b1a29d8f in __libc_start_call_main (/lib/x86_64-linux-gnu/libc.so.6+0x29d8f)
+
+  Uninitialized value was created by a heap allocation
+    #0 0x5611a2b0a1c2 in malloc (/tmp/target+0x8a1c2)
+
+SUMMARY: MemorySanitizer: use-of-uninitialized-value /src/target.c:18:7 in check
+Exiting
+`
+
+const ubsanReport = `/src/target.c:21:12: runtime error: index 7 out of bounds for type 'int [4]'
+    #0 0x55e0f1c2d3a4 in lookup /src/target.c:21:12 (/tmp/target+0x2d3a4)
+    #1 0x55e0f1c2d4b8 in main /src/target.c:35:3 (/tmp/target+0x2d4b8)
+
+SUMMARY: UndefinedBehaviorSanitizer: undefined-behavior /src/target.c:21:12 in
+`
+
+func TestParseSanReport(t *testing.T) {
+	tests := []struct {
+		name   string
+		stderr string
+		want   sanReport
+	}{
+		{"asan", asanReport, sanReport{
+			sanitizer: "AddressSanitizer",
+			bugType:   "heap-buffer-overflow",
+			pc:        0x55d4c7a1b2c3,
+			frames: []string{"target+0x1b2c2", "target+0x1b3f0",
+				"libc.so.6+0x29d8f", "target+0x1a0e4"},
+		}},
+		{"msan", msanReport, sanReport{
+			sanitizer: "MemorySanitizer",
+			bugType:   "use-of-uninitialized-value",
+			pc:        0x5611a2b4c9e1,
+			frames: []string{"target+0xc9e1", "target+0xca55",
+				"libc.so.6+0x29d8f"},
+		}},
+		{"ubsan", ubsanReport, sanReport{
+			sanitizer: "UndefinedBehaviorSanitizer",
+			bugType:   "index N out of bounds for type 'int [N]'",
+			pc:        0x55e0f1c2d3a4,
+			location:  "/src/target.c:21:12",
+			frames:    []string{"target+0x2d3a4", "target+0x2d4b8"},
+		}},
+	}
+	for _, test := range tests {
+		rep, ok := parseSanReport([]byte(test.stderr))
+		if !ok {
+			t.Errorf("%s: report not parsed.", test.name)
+		} else if !reflect.DeepEqual(rep, test.want) {
+			t.Errorf("%s: parsed %+v, expected %+v.", test.name, rep, test.want)
+		}
+	}
+
+	if _, ok := parseSanReport([]byte("Segmentation fault\n")); ok {
+		t.Error("Parsed a report without sanitizer header.")
+	}
+}
+
+// Another run of the same bug (ASLR, other faulting address and values) has
+// the same key.
+func TestSanReportKey(t *testing.T) {
+	tests := []struct {
+		report, other string
+	}{
+		{asanReport, strings.NewReplacer(
+			"0x55d4c7a1", "0x563b02c4",
+			"0x602000000011", "0x6020000000f1",
+			"0x7f3a9c", "0x7fe810",
+			"==4321==", "==99==").Replace(asanReport)},
+		{ubsanReport, strings.NewReplacer(
+			"index 7", "index 12",
+			"0x55e0f1c2", "0x5603aa71").Replace(ubsanReport)},
+	}
+	for i, test := range tests {
+		rep, ok := parseSanReport([]byte(test.report))
+		otherRep, okOther := parseSanReport([]byte(test.other))
+		if !ok || !okOther {
+			t.Fatalf("Test %d: report not parsed.", i)
+		} else if rep.pc == otherRep.pc {
+			t.Errorf("Test %d: addresses weren't changed.", i)
+		} else if rep.key() != otherRep.key() {
+			t.Errorf("Test %d: keys differ: %s != %s.", i, rep.key(),
+				otherRep.key())
+		}
+	}
+
+	// Only the top frames are in the key; another bug type changes it.
+	asan, _ := parseSanReport([]byte(asanReport))
+	if strings.Contains(asan.key(), "target+0x1a0e4") {
+		t.Errorf("Key has more than %d frames: %s.", reportKeyFrameN,
+			asan.key())
+	}
+	uaf, _ := parseSanReport([]byte(strings.Replace(asanReport,
+		"heap-buffer-overflow", "heap-use-after-free", -1)))
+	if uaf.key() == asan.key() {
+		t.Error("Different bug types have the same key.")
+	}
+}
+
+// Sanitizer findings are crashes: UBSAN needs its options too.
+func TestSanitizerEnvs(t *testing.T) {
+	t.Setenv(ubsanVar, "")
+	os.Unsetenv(ubsanVar)
+	envs, usesMsan := getSanitizerEnvs([]byte("__ubsan_handle_add_overflow"))
+	if usesMsan || len(envs) != 3 {
+		t.Fatalf("Sanitizer environment: %q.", envs)
+	}
+	if ubsanEnv := envs[2]; !strings.HasPrefix(ubsanEnv, ubsanVar+"=") ||
+		!strings.Contains(ubsanEnv, "halt_on_error=1") ||
+		!strings.Contains(ubsanEnv, "abort_on_error=1") {
+		t.Errorf("UBSAN environment: %s.", ubsanEnv)
+	}
+	if envs, _ := getSanitizerEnvs([]byte("no sanitizer")); len(envs) != 0 {
+		t.Errorf("Sanitizer environment without sanitizer: %q.", envs)
+	}
+}
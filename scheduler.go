@@ -4,7 +4,6 @@ import (
 	"fmt"
 
 	"math/rand"
-	"sort"
 	"time"
 )
 
@@ -16,15 +15,25 @@ type scheduler struct {
 	threadChan chan *thread
 
 	seedsChan chan []*seedT
+
+	// Seeds whose round ended, back in the queue.
+	seedDoneChan chan *seedT
+
+	cmplog bool // Threads have a cmplog binary: use input-to-state.
+
+	phase phasePlan // Rounds, power schedule, fitness stack and mutator.
 }
 
-func newScheduler(threads []*thread, initSeeds []*seedT, fitChan chan runT) (
-	sched *scheduler) {
+func newScheduler(threads []*thread, initSeeds []*seedT, fitChan chan runT,
+	phase phasePlan) (sched *scheduler) {
 
 	sched = &scheduler{
-		newSeedChan: make(chan *seedT),
-		threadChan:  make(chan *thread),
-		seedsChan:   make(chan []*seedT),
+		newSeedChan:  make(chan *seedT),
+		threadChan:   make(chan *thread),
+		seedsChan:    make(chan []*seedT),
+		seedDoneChan: make(chan *seedT),
+		cmplog:       threads[0].cmpTgt != nil,
+		phase:        phase,
 	}
 
 	go func() {
@@ -46,8 +55,20 @@ func newScheduler(threads []*thread, initSeeds []*seedT, fitChan chan runT) (
 func (sched *scheduler) schedule(fitChan chan runT, threadRunningN int) {
 	var seeds []*seedT
 	var sleepingThreads []*thread
+	var roundN int // Rounds started. Budget: phase rounds per seed.
+	queue := &seedQueue{}
+	byHash := make(map[uint64]*seedT)
 
 	_, sigChan := intChans.add() // Get notified when interrupted.
+	wakeThread := func() {
+		if len(sleepingThreads) > 0 {
+			threadRunningN++
+			l := len(sleepingThreads)
+			t := sleepingThreads[l-1]
+			sleepingThreads = sleepingThreads[:l-1]
+			go func() { sched.threadChan <- t }()
+		}
+	}
 
 	fuzzContinue := true
 	printTicker := time.NewTicker(printTickT)
@@ -57,61 +78,65 @@ func (sched *scheduler) schedule(fitChan chan runT, threadRunningN int) {
 			fuzzContinue = false
 			break
 		case _ = <-printTicker.C:
-			printStatus(seeds)
+			printStatus(seeds, roundN, sched.phase.Rounds)
+			queue.refresh(sched.phase.Power, getPowerStats(seeds))
 
 		case newSeed := <-sched.newSeedChan:
+			seedPool.add(newSeed.input, newSeed.hash)
 			if newSeed.exec == nil {
+				newSeed.rngSeed = mutatorSeed(newSeed.hash)
 				newSeed.exec = &executor{
-					ig:             makeRatioMutator(newSeed.input, mutationRatio),
-					securityPolicy: falseFitFunc{},
+					ig:             sched.makeMutator(newSeed),
+					securityPolicy: crashFitFunc{},
 					fitChan:        fitChan,
-					crashChan:      devNullFitChan,
+					crashChan:      crashColl.channel(),
+					hangChan:       hangColl.channel(),
+					parentHash:     newSeed.hash,
 				}
 			} else {
 				newSeed.exec.fitChan = fitChan
+				if sched.changesMutator(newSeed) {
+					newSeed.exec.ig = sched.makeMutator(newSeed)
+				}
 			}
-			seeds = append(seeds, newSeed)
-			//
-			if len(sleepingThreads) > 0 {
-				threadRunningN++
-				l := len(sleepingThreads)
-				t := sleepingThreads[l-1]
-				sleepingThreads = sleepingThreads[:l-1]
-				go func() { sched.threadChan <- t }()
+			if parent, ok := byHash[newSeed.parent]; ok {
+				parent.childN++
 			}
+			seeds = append(seeds, newSeed)
+			byHash[newSeed.hash] = newSeed
+			newSeed.energy = seedEnergy(newSeed, sched.phase.Power,
+				getPowerStats(seeds))
+			queue.push(newSeed)
+			wakeThread()
+
+		case seed := <-sched.seedDoneChan:
+			seed.energy = seedEnergy(seed, sched.phase.Power,
+				getPowerStats(seeds))
+			queue.push(seed)
+			wakeThread()
 
 		case t := <-sched.threadChan:
 			threadRunningN--
-			// Is this sort too slow?
-			// Can be optimized but need a special structure :/
-			sort.Slice(seeds, func(i, j int) bool {
-				if a, b := seeds[i].running, seeds[j].running; a != b {
-					return a && !b
-				}
-				return seeds[i].execN > seeds[j].execN
-			})
-			seed := seeds[len(seeds)-1]
-			if seed.execN >= fuzzRoundN {
+			if sched.phase.Rounds != unlimitedRounds &&
+				roundN >= sched.phase.Rounds*len(seeds) {
 				if threadRunningN == 0 {
 					fuzzContinue = false
 					break
 				}
 				sleepingThreads = append(sleepingThreads, t)
 				continue
-			} else if seed.running {
-				threadRunningN++
-				go sched.postponeThread(t)
+			}
+			seed, ok := queue.pop()
+			if !ok { // All seeds are being fuzzed.
+				sleepingThreads = append(sleepingThreads, t)
 				continue
 			}
 
 			if seed.exec.discoveryFit == nil {
-				fm := fitnessMultiplexer{newBrCovFitFunc(), newPCAFitFunc()}
-				if trackGlbFreqs {
-					fm = append(fm, freqFitFunc{})
-				}
-				seed.exec.discoveryFit = fm
+				seed.exec.discoveryFit = newFitnessStack(sched.phase.Fitness)
 			}
 			seed.execN, seed.running = seed.execN+1, true
+			roundN++
 
 			threadRunningN++
 			go sched.execSeed(t, seed)
@@ -121,29 +146,63 @@ func (sched *scheduler) schedule(fitChan chan runT, threadRunningN int) {
 	sched.seedsChan <- seeds
 }
 
-func (sched *scheduler) postponeThread(t *thread) {
-	r := time.Duration(rand.Intn(700)) + 300
-	time.Sleep(roundTime + r*time.Millisecond)
-	sched.threadChan <- t
+// If the phase sets another mutator or havoc stack than the seed's (of an
+// earlier phase).
+func (sched *scheduler) changesMutator(seed *seedT) bool {
+	return len(sched.phase.Mutator) > 0 ||
+		(seed.mutOpts.kind == havocMutKind &&
+			sched.phase.Stack != seed.mutOpts.stackMax)
 }
+
+// Mutator of the phase (or -mutator), from the seed's RNG seed for the phase.
+func (sched *scheduler) makeMutator(seed *seedT) (ig inputGen) {
+	rngSeed := phaseSeed(seed.rngSeed, sched.phase.index)
+	opts := mutConf
+	if len(sched.phase.Mutator) > 0 {
+		opts.kind = sched.phase.Mutator
+	}
+	if sched.phase.Stack > 0 {
+		opts.stackMax = sched.phase.Stack
+	}
+	opts.kind = seedMutKind(opts.kind, rngSeed)
+	seed.mutOpts, seed.mutPhase = opts, sched.phase.index
+	ig = buildMutator(seed.input, seed.hash, rngSeed, opts)
+	if sched.cmplog {
+		ig = newI2SMutator(seed.input, ig)
+	}
+	return ig
+}
+
 func (sched scheduler) execSeed(t *thread, seed *seedT) {
 	t.execChan <- seed.exec
 	<-t.endChan
 	seed.running = false
+	sched.seedDoneChan <- seed
 	sched.threadChan <- t
 }
 
-func printStatus(seeds []*seedT) {
-	var cnt, totExecN int
+func printStatus(seeds []*seedT, roundN, rounds int) {
+	fuzzedN := rounds // Rounds of a fuzzed seed.
+	if rounds == unlimitedRounds {
+		fuzzedN = 1
+	}
+	var cnt int
 	for _, seed := range seeds {
-		totExecN += seed.execN
-		if seed.execN >= fuzzRoundN {
+		if seed.execN >= fuzzedN {
 			cnt++
 		}
 	}
 
-	goal := fuzzRoundN * len(seeds)
-	progress := 100 * float64(totExecN) / float64(goal)
-	fmt.Printf("Fuzzed seeds: %d/%d\tprogress: %d/%d (%.1f%%)\n",
-		cnt, len(seeds), totExecN, goal, progress)
+	goal := rounds * len(seeds)
+	progress := fmt.Sprintf("%d/%d (%.1f%%)", roundN, goal,
+		100*float64(roundN)/float64(goal))
+	if rounds == unlimitedRounds {
+		progress = fmt.Sprintf("%d rounds (until budget)", roundN)
+	}
+	crashN, uniqCrashN := crashColl.counts()
+	hangN, uniqHangN := hangColl.counts()
+	fmt.Printf("Fuzzed seeds: %d/%d\tprogress: %s\t"+
+		"crashes: %d (%d unique)\thangs: %d (%d unique)\trestarts: %d\n",
+		cnt, len(seeds), progress, crashN, uniqCrashN, hangN, uniqHangN,
+		getTargetRestartN())
 }
@@ -5,6 +5,7 @@ import (
 	"log"
 
 	"syscall"
+	"time"
 
 	"gonum.org/v1/gonum/mat"
 )
@@ -17,15 +18,27 @@ type runT struct {
 	crashed bool
 	hanged  bool
 
+	execTime time.Duration
+
 	trace []byte // Only used if is fit.
 	hash  uint64
+
+	parent  uint64 // Hash of the seed this input was generated from.
+	partner uint64 // Hash of the seed it was spliced with (if any).
 }
 
 type seedT struct {
 	runT
 
-	execN   int
-	running bool
+	execN    int
+	running  bool
+	rngSeed  int64          // Of its mutator (see phaseSeed).
+	mutOpts  mutatorOptions // Of its mutator.
+	mutPhase int            // Phase which built its mutator.
+
+	// Power schedule
+	energy float64
+	childN int // Seeds found while fuzzing it.
 
 	exec *executor
 }
@@ -72,6 +85,20 @@ func getPCA(seed *seedT) (ok bool, pca *dynamicPCA) {
 	}
 	return ok, pca
 }
+func getEntropyFF(seed *seedT) (ok bool, eff *entropyFitFunc) {
+	if seed == nil || seed.exec == nil {
+		return
+	}
+	//
+	if ff, okConv := seed.exec.discoveryFit.(fitnessMultiplexer); okConv {
+		for _, ffi := range ff {
+			if fit, okConv := ffi.(*entropyFitFunc); okConv {
+				ok, eff = true, fit
+			}
+		}
+	}
+	return ok, eff
+}
 func getDivFF(seed *seedT) (ok bool, df *divFitness) {
 	if seed == nil || seed.exec == nil {
 		return
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// *****************************************************************************
+// **************************** Simulated Target *******************************
+// A deterministic "program" to test Hemipt with: the trace only depends on
+// the (first bytes of the) input. Inputs starting with the crash magic crash,
+// with the hang magic hang. Optionally, the input is compared to a magic value
+// (one more branch if equal), logged for cmplog if a cmplog map is given, and
+// some edges are hit at random (to test stability calibration). The fork
+// server can also offer an AFL++ auto dictionary and shared memory test case
+// delivery, and die or stop replying after some test cases (to test target
+// restarts).
+// Available in-process (Go harness "sim") or as an AFL fork server (Hemipt
+// simTargetCmd sub-command), so no AFL-compiled binary is needed.
+
+const (
+	simTargetCmd = "simtarget"
+
+	simMaxLen   = 64 // Default number of input bytes with branches.
+	simHangTime = 10 * time.Second
+
+	simMagicOff   = 4 // Offset of the input bytes compared to the magic.
+	simMagicCmpID = 0x1337
+
+	simNoiseEdge = 0xbeef // First of the nondeterministic edges.
+)
+
+var (
+	simCrashMagic = []byte("CR")
+	simHangMagic  = []byte("HA")
+)
+
+func simHarness(input, trace []byte) {
+	simTrace(input, trace, simMaxLen)
+	if bytes.HasPrefix(input, simCrashMagic) {
+		panic("simulated crash")
+	} else if bytes.HasPrefix(input, simHangMagic) {
+		time.Sleep(simHangTime)
+	}
+}
+
+func simTrace(input, trace []byte, depth int) {
+	size := uint32(len(trace))
+	var prev uint32
+	for i, b := range input {
+		if i >= depth {
+			break
+		}
+		cur := (uint32(i)*7919 + uint32(b>>5)*104729) % size
+		trace[(cur^prev)%size]++
+		prev = cur >> 1
+	}
+}
+
+// *****************************************************************************
+// ************************ Simulated Fork Server ******************************
+// Go can't fork: the fork server "forks" by re-executing itself (-child) for
+// each test case. In persistent mode, the child runs test cases until killed,
+// raising SIGSTOP after each one (like an AFL persistent loop).
+
+type simConfig struct {
+	depth       int
+	mapSize     int // Advertised to the fuzzer (AFL++ option) if not 0.
+	persistent  bool
+	crash, hang []byte
+	magic       []byte // Up to 8 bytes (integer comparison).
+	noise       int    // Number of edges one of which is randomly hit.
+	autodict    []string
+	shmFuzz     bool // Offer shared memory test case delivery.
+	shmIn       bool // Child: read the test case from shared memory.
+	dieN        int  // Fork server exits after dieN test cases if not 0.
+	wedgeN      int  // Fork server stops after wedgeN test cases if not 0.
+}
+
+func simTarget(args []string) {
+	var cfg simConfig
+	var crash, hang, magic, autodict string
+	fs := flag.NewFlagSet(simTargetCmd, flag.ExitOnError)
+	child := fs.Bool("child", false, "Run the test case (fork server child)")
+	fs.IntVar(&cfg.depth, "depth", simMaxLen, "Number of input bytes with branches")
+	fs.IntVar(&cfg.mapSize, "mapsize", 0, "Map size advertised to the fuzzer")
+	fs.BoolVar(&cfg.persistent, "persistent", false, "Persistent mode")
+	fs.StringVar(&crash, "crash", string(simCrashMagic), "Crashing input prefix")
+	fs.StringVar(&hang, "hang", string(simHangMagic), "Hanging input prefix")
+	fs.StringVar(&magic, "magic", "", fmt.Sprintf(
+		"Magic value (up to 8 bytes) compared to the input at offset %d",
+		simMagicOff))
+	fs.IntVar(&cfg.noise, "noise", 0,
+		"Number of nondeterministic edges (one is hit at random each run)")
+	fs.StringVar(&autodict, "autodict", "",
+		"Comma-separated tokens offered as AFL++ auto dictionary")
+	fs.BoolVar(&cfg.shmFuzz, "shmfuzz", false,
+		"Offer AFL++ shared memory test case delivery")
+	fs.BoolVar(&cfg.shmIn, "shmin", false,
+		"Read the test case from shared memory (fork server child)")
+	fs.IntVar(&cfg.dieN, "die", 0,
+		"Fork server exits after this number of test cases (0: never)")
+	fs.IntVar(&cfg.wedgeN, "wedge", 0, "Fork server stops (SIGSTOP) after "+
+		"this number of test cases (0: never)")
+	fs.Parse(args)
+	if len(autodict) > 0 {
+		cfg.autodict = strings.Split(autodict, ",")
+	}
+	cfg.crash, cfg.hang, cfg.magic = []byte(crash), []byte(hang), []byte(magic)
+	if len(cfg.magic) > 8 {
+		log.Fatal("Magic value too long.")
+	}
+
+	if !*child && simForkserver(args, cfg) {
+		return
+	}
+	// Child, or not run by a fuzzer: run the test case.
+	simRun(fs.Args(), cfg)
+}
+
+// Returns false if there is no fuzzer on the other end.
+func simForkserver(args []string, cfg simConfig) bool {
+	encoded := make([]byte, 4)
+	var status uint32
+	if cfg.mapSize > 0 {
+		status |= uint32(fsOptEnabled|fsOptMapSize) |
+			(uint32(cfg.mapSize-1)<<1)&0x00fffffe
+	}
+	if len(cfg.autodict) > 0 {
+		status |= fsOptEnabled | fsOptAutodict
+	}
+	if cfg.shmFuzz {
+		status |= fsOptEnabled | fsOptShdmemFuzz
+	}
+	binary.LittleEndian.PutUint32(encoded, status)
+	ctlPipe := os.NewFile(forksrvFd, "ctl")
+	stPipe := os.NewFile(forksrvFd+1, "st")
+	if _, err := stPipe.Write(encoded); err != nil {
+		return false
+	}
+	var shmIn bool
+	if len(cfg.autodict) > 0 || cfg.shmFuzz {
+		reply, ok := simOptionsReply(ctlPipe, stPipe, cfg.autodict)
+		if !ok {
+			log.Fatal("Couldn't read the fuzzer options.")
+		}
+		shmIn = cfg.shmFuzz && reply&fsOptShdmemFuzz != 0
+	}
+	syscall.CloseOnExec(forksrvFd)
+	syscall.CloseOnExec(forksrvFd + 1)
+
+	self, err := os.Executable()
+	if err != nil {
+		log.Fatalf("Couldn't find own executable: %v.\n", err)
+	}
+	childArgs := []string{self, simTargetCmd, "-child"}
+	if shmIn {
+		childArgs = append(childArgs, "-shmin")
+	}
+	childArgs = append(childArgs, args...)
+	procAttr := &syscall.ProcAttr{Env: os.Environ(), Files: []uintptr{0, 1, 2}}
+
+	var pid int
+	var stopped bool
+	for runN := 0; ; runN++ {
+		if _, err := io.ReadFull(ctlPipe, encoded); err != nil {
+			os.Exit(0) // Fuzzer is gone.
+		} else if cfg.dieN > 0 && runN == cfg.dieN {
+			if stopped {
+				syscall.Kill(pid, syscall.SIGKILL)
+			}
+			os.Exit(1)
+		} else if cfg.wedgeN > 0 && runN == cfg.wedgeN {
+			syscall.Kill(os.Getpid(), syscall.SIGSTOP) // Until killed.
+		}
+		wasKilled := binary.LittleEndian.Uint32(encoded) != 0
+		if stopped && wasKilled {
+			syscall.Wait4(pid, nil, 0, nil)
+			stopped = false
+		}
+
+		if stopped {
+			err = syscall.Kill(pid, syscall.SIGCONT)
+		} else {
+			pid, err = syscall.ForkExec(self, childArgs, procAttr)
+		}
+		if err != nil {
+			log.Fatalf("Couldn't start child: %v.\n", err)
+		}
+		binary.LittleEndian.PutUint32(encoded, uint32(pid))
+		stPipe.Write(encoded)
+
+		var status syscall.WaitStatus
+		syscall.Wait4(pid, &status, syscall.WUNTRACED, nil)
+		stopped = status.Stopped()
+		binary.LittleEndian.PutUint32(encoded, uint32(status))
+		stPipe.Write(encoded)
+	}
+}
+
+// The auto dictionary (if any) is sent if the fuzzer accepts it.
+func simOptionsReply(ctlPipe, stPipe *os.File, tokens []string) (
+	reply uint32, ok bool) {
+
+	encoded := make([]byte, 4)
+	if _, err := io.ReadFull(ctlPipe, encoded); err != nil {
+		return reply, ok
+	}
+	reply = binary.LittleEndian.Uint32(encoded)
+	if len(tokens) == 0 || reply&fsOptAutodict == 0 {
+		return reply, true
+	}
+	var dict []byte
+	for _, tok := range tokens {
+		dict = append(dict, byte(len(tok)))
+		dict = append(dict, tok...)
+	}
+	binary.LittleEndian.PutUint32(encoded, uint32(len(dict)))
+	_, err := stPipe.Write(append(encoded, dict...))
+	return reply, err == nil
+}
+
+func simRun(args []string, cfg simConfig) {
+	trace := simAttachShm(cfg.mapSize)
+	var cmpMap []byte
+	if id, err := strconv.Atoi(os.Getenv(cmplogEnvVar)); err == nil {
+		cmpMap = attachShm(id, cmpMapSize)
+	}
+	var shmIn []byte
+	if cfg.shmIn {
+		id, err := strconv.Atoi(os.Getenv(shmFuzzEnvVar))
+		if err != nil {
+			log.Fatalf("No test case shared memory: %v.\n", err)
+		}
+		shmIn = attachShm(id, maxShmFuzzLen+4)
+	}
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for {
+		var input []byte
+		var err error
+		if shmIn != nil { // Length, then the test case.
+			n := binary.LittleEndian.Uint32(shmIn)
+			input = append(input, shmIn[4:4+n]...)
+		} else if len(args) > 0 {
+			input, err = ioutil.ReadFile(args[0])
+		} else {
+			input, err = ioutil.ReadAll(os.Stdin)
+		}
+		if err != nil {
+			log.Fatalf("Couldn't read input: %v.\n", err)
+		}
+
+		simTrace(input, trace, cfg.depth)
+		if len(cfg.magic) > 0 {
+			simMagicCmp(input, trace, cmpMap, cfg.magic)
+		}
+		if cfg.noise > 0 {
+			trace[(simNoiseEdge+r.Intn(cfg.noise))%len(trace)]++
+		}
+		if bytes.HasPrefix(input, cfg.crash) {
+			debug.SetTraceback("crash") // Die from SIGABRT, not exit(2).
+			panic("simulated crash")
+		} else if bytes.HasPrefix(input, cfg.hang) {
+			time.Sleep(simHangTime)
+		}
+
+		if !cfg.persistent {
+			return
+		}
+		syscall.Kill(os.Getpid(), syscall.SIGSTOP)
+	}
+}
+
+func simAttachShm(mapSize int) (trace []byte) {
+	size := defaultMapSize
+	if mapSize > 0 {
+		size = mapSize
+	} else if s, err := strconv.Atoi(os.Getenv(mapSizeEnvVar)); err == nil {
+		size = s
+	}
+	id, err := strconv.Atoi(os.Getenv(shmEnvVar))
+	if err != nil { // Not run by a fuzzer.
+		return make([]byte, size)
+	}
+	return attachShm(id, size)
+}
+
+func attachShm(id, size int) (seg []byte) {
+	seg, err := unix.SysvShmAttach(id, 0, 0)
+	if err != nil {
+		log.Fatalf("Problem attaching segment: %v\n", err)
+	} else if len(seg) > size {
+		seg = seg[:size]
+	}
+	return seg
+}
+
+// Integer comparison of the input bytes at simMagicOff with the magic, logged
+// like AFL++ cmplog instrumentation does (operand 0 is the input).
+func simMagicCmp(input, trace, cmpMap, magic []byte) {
+	var in, mag [8]byte
+	if len(input) > simMagicOff {
+		copy(in[:len(magic)], input[simMagicOff:])
+	}
+	copy(mag[:], magic)
+	if in == mag {
+		trace[simMagicCmpID%len(trace)]++
+	}
+	if cmpMap == nil {
+		return
+	}
+
+	header := cmpMap[simMagicCmpID*cmpHeaderLen:]
+	hits := binary.LittleEndian.Uint64(header) & 0xffffff
+	if hits < cmpMapH {
+		op := cmpMap[cmpLogOffset+(simMagicCmpID*cmpMapH+int(hits))*cmpOpLen:]
+		copy(op[:8], in[:])
+		copy(op[8:16], mag[:])
+	}
+	hits++
+	shape := uint64(len(magic) - 1)
+	binary.LittleEndian.PutUint64(header,
+		hits|uint64(simMagicCmpID)<<24|shape<<48|cmpTypeIns<<53)
+}
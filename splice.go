@@ -0,0 +1,207 @@
+package main
+
+import (
+	"math/rand"
+	"sync"
+)
+
+// *****************************************************************************
+// ******************************** Seed Pool **********************************
+// Seeds (of all fuzzing loops) other seeds can be spliced with. Once the global
+// projection is computed (divergence phase), seeds also have their coordinates
+// and region, to pick partners with a different behavior.
+
+type poolEntry struct {
+	input  []byte
+	hash   uint64
+	proj   []float64 // nil if not projected.
+	region int
+}
+
+type seedPoolT struct {
+	mtx     sync.RWMutex
+	entries []*poolEntry
+	byHash  map[uint64]*poolEntry
+}
+
+var seedPool = newSeedPool()
+
+func newSeedPool() *seedPoolT {
+	return &seedPoolT{byHash: make(map[uint64]*poolEntry)}
+}
+
+func (pool *seedPoolT) add(input []byte, hash uint64) {
+	pool.mtx.Lock()
+	defer pool.mtx.Unlock()
+	if _, ok := pool.byHash[hash]; ok {
+		return
+	}
+	entry := &poolEntry{input: input, hash: hash, region: -1}
+	pool.entries = append(pool.entries, entry)
+	pool.byHash[hash] = entry
+}
+
+func (pool *seedPoolT) setProjections(glbProj globalProjection,
+	regions []regionT) {
+
+	pool.mtx.Lock()
+	defer pool.mtx.Unlock()
+	for i, seed := range glbProj.cleanedSeeds {
+		entry, ok := pool.byHash[seed.hash]
+		if !ok {
+			continue
+		}
+		entry.proj = glbProj.seedProjs[i].RawRowView(0)
+		if len(regions) > 0 {
+			entry.region, _ = closestRegion(regions, entry.proj)
+		}
+	}
+}
+
+const (
+	spliceRandom = "random"
+	spliceFar    = "far"    // Far in the global projection.
+	spliceRegion = "region" // In another region.
+)
+
+// Partner of the seed to splice with. If the policy can't be applied (no
+// projection yet), it is picked at random. The far and region policies
+// consider spliceCandN random candidates, or all the seeds if there are not
+// more.
+func (pool *seedPoolT) pickPartner(r *rand.Rand, hash uint64, policy string) (
+	partner *poolEntry, ok bool) {
+
+	pool.mtx.RLock()
+	defer pool.mtx.RUnlock()
+	if len(pool.entries) < 2 {
+		return partner, ok
+	}
+	pick := func() *poolEntry {
+		for {
+			if e := pool.entries[r.Intn(len(pool.entries))]; e.hash != hash {
+				return e
+			}
+		}
+	}
+
+	candidates := func() (cands []*poolEntry) {
+		if len(pool.entries) > spliceCandN+1 {
+			for i := 0; i < spliceCandN; i++ {
+				cands = append(cands, pick())
+			}
+			return cands
+		}
+		for _, i := range r.Perm(len(pool.entries)) {
+			if e := pool.entries[i]; e.hash != hash {
+				cands = append(cands, e)
+			}
+		}
+		return cands
+	}
+
+	self := pool.byHash[hash]
+	switch {
+	case policy == spliceFar && self != nil && self.proj != nil:
+		maxDist := -1.0
+		for _, cand := range candidates() {
+			if cand.proj == nil {
+				continue
+			}
+			if dist := euclideanDist(self.proj, cand.proj); dist > maxDist {
+				maxDist, partner = dist, cand
+			}
+		}
+
+	case policy == spliceRegion && self != nil && self.region >= 0:
+		for _, cand := range candidates() {
+			if cand.region >= 0 && cand.region != self.region {
+				partner = cand
+				break
+			}
+		}
+	}
+	if partner == nil {
+		partner = pick()
+	}
+
+	ok = true
+	return partner, ok
+}
+
+// *****************************************************************************
+// ***************************** Splice Mutator ********************************
+// Like AFL splicing: the seed is crossed with a partner (head of the seed, tail
+// of the partner) at an offset where they differ, then havoc mutations are
+// stacked on the result. Otherwise, the base generator is used.
+
+type spliceMutator struct {
+	r        *rand.Rand
+	seedIn   []byte
+	seedHash uint64
+	policy   string
+
+	base  inputGen
+	havoc havocMutator
+
+	lastPartner uint64 // Partner of the last test case (0 if not spliced).
+}
+
+func newSpliceMutator(seedIn []byte, seedHash uint64, base inputGen,
+	opts mutatorOptions, rngSeed int64) *spliceMutator {
+
+	return &spliceMutator{
+		r:        rand.New(rand.NewSource(rngSeed)),
+		seedIn:   seedIn,
+		seedHash: seedHash,
+		policy:   opts.splice,
+		base:     base,
+		havoc: makeHavocMutator(nil, opts.stackMax, opts.dict,
+			deriveSeed(rngSeed, 2)),
+	}
+}
+
+func (sMut *spliceMutator) generate() (testCase []byte) {
+	sMut.lastPartner = 0
+	if sMut.r.Float64() >= spliceRatio {
+		return sMut.base.generate()
+	}
+	partner, ok := seedPool.pickPartner(sMut.r, sMut.seedHash, sMut.policy)
+	if !ok {
+		return sMut.base.generate()
+	}
+	testCase, ok = spliceInputs(sMut.r, sMut.seedIn, partner.input)
+	if !ok {
+		return sMut.base.generate()
+	}
+	sMut.lastPartner = partner.hash
+	return sMut.havoc.stack(testCase)
+}
+
+func (sMut *spliceMutator) partner() uint64 { return sMut.lastPartner }
+
+// Not ok if the inputs don't differ enough (AFL locate_diffs).
+func spliceInputs(r *rand.Rand, head, tail []byte) (spliced []byte, ok bool) {
+	n := len(head)
+	if len(tail) < n {
+		n = len(tail)
+	}
+	first, last := -1, -1
+	for i := 0; i < n; i++ {
+		if head[i] != tail[i] {
+			if first < 0 {
+				first = i
+			}
+			last = i
+		}
+	}
+	if first < 0 || last < 2 || first == last {
+		return spliced, ok
+	}
+
+	split := first + r.Intn(last-first)
+	spliced = make([]byte, 0, len(tail))
+	spliced = append(spliced, head[:split]...)
+	spliced = append(spliced, tail[split:]...)
+	ok = true
+	return spliced, ok
+}
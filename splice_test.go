@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestSpliceInputs(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	head := []byte("AAAAAAAAAAAAAAAA")
+	tail := []byte("AAAABBBBBBBBBBBBtail")
+	for i := 0; i < 100; i++ {
+		spliced, ok := spliceInputs(r, head, tail)
+		if !ok {
+			t.Fatal("Differing inputs not spliced.")
+		}
+		split := bytes.IndexByte(spliced, 'B')
+		if len(spliced) != len(tail) || split < 4 ||
+			!bytes.Equal(spliced[split:], tail[split:]) {
+			t.Fatalf("Bad splice of %q and %q: %q.", head, tail, spliced)
+		}
+	}
+
+	for _, tail := range [][]byte{head, []byte("AAAAAAAAAAAAAAAB")} {
+		if _, ok := spliceInputs(r, head, tail); ok {
+			t.Errorf("Inputs %q and %q differ too little to be spliced.",
+				head, tail)
+		}
+	}
+}
+
+func TestPickPartner(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	pool := newSeedPool()
+	if _, ok := pool.pickPartner(r, 1, spliceRandom); ok {
+		t.Error("Partner found in an empty pool.")
+	}
+	projs := [][]float64{{0, 0}, {0.1, 0}, {0, 0.1}, {10, 10}}
+	for i, proj := range projs {
+		hash := uint64(i + 1)
+		pool.add([]byte{byte(i)}, hash)
+		pool.byHash[hash].proj = proj
+		pool.byHash[hash].region = 0
+	}
+	pool.byHash[4].region = 1
+
+	// Not more seeds than candidates: whatever the RNG.
+	for _, policy := range []string{spliceFar, spliceRegion} {
+		for i := int64(0); i < 100; i++ {
+			r := rand.New(rand.NewSource(i))
+			partner, ok := pool.pickPartner(r, 1, policy)
+			if !ok || partner.hash != 4 {
+				t.Fatalf("%s partner of seed 1 isn't seed 4.", policy)
+			}
+		}
+	}
+	for i := 0; i < 10; i++ {
+		if partner, _ := pool.pickPartner(r, 2, spliceRandom); partner.hash == 2 {
+			t.Fatal("Seed is its own partner.")
+		}
+	}
+}
+
+// With cmplog, the splice mutator is wrapped by the input-to-state one: the
+// partner is still reported (for the lineage).
+func TestI2SPartner(t *testing.T) {
+	defer func(conf mutatorOptions, pool *seedPoolT) {
+		mutConf, seedPool = conf, pool
+	}(mutConf, seedPool)
+	mutConf.splice = spliceRandom
+	seedPool = newSeedPool()
+	seedPool.add([]byte("AAAAAAAAAAAAAAAA"), 1)
+	seedPool.add([]byte("AAAABBBBBBBBBBBB"), 2)
+
+	sched := &scheduler{cmplog: true}
+	seed := &seedT{runT: runT{input: []byte("AAAAAAAAAAAAAAAA"), hash: 1}}
+	seed.rngSeed = 1
+	ig := sched.makeMutator(seed)
+	cu, okCmp := ig.(cmpUser)
+	pg, okPartner := ig.(partnerGen)
+	if !okCmp || !okPartner {
+		t.Fatal("Mutator isn't an input-to-state one reporting partners.")
+	}
+	cu.setCmps([]cmpPair{{v0: []byte("AAAA"), v1: []byte("CCCC")}})
+
+	var candN, splicedN int
+	for i := 0; i < 1000; i++ {
+		testCase := ig.generate()
+		if bytes.Contains(testCase, []byte("CCCC")) {
+			if pg.partner() != 0 {
+				t.Fatal("Input-to-state test case has a partner.")
+			}
+			candN++
+		} else if pg.partner() == 2 {
+			splicedN++
+		} else if pg.partner() != 0 {
+			t.Fatalf("Partner is %d, expected 2.", pg.partner())
+		}
+	}
+	if candN == 0 || splicedN == 0 {
+		t.Errorf("%d input-to-state and %d spliced test cases.", candN,
+			splicedN)
+	}
+}
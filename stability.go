@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+
+	"sort"
+)
+
+// *****************************************************************************
+// ******************************* Stability ***********************************
+// Each seed is run several times before fuzzing. Trace indices whose value
+// changes between runs of the same input (nondeterministic edges) are masked:
+// zeroed before coverage fitness, trace hashing and PCA, so that noise isn't
+// taken for new behaviors or variance.
+
+// Indices of the variable edges. Only written before fuzzing starts.
+var varEdges []int
+
+type seedStability struct {
+	hash         uint64
+	runN         int
+	edgeN, varN  int
+	stabilityPct float64
+}
+
+type stabilityInfo struct {
+	seeds        []seedStability
+	edgeN, varN  int // Over all seeds.
+	stabilityPct float64
+}
+
+func calibrateStability(threads []*thread, initSeeds []*seedT) (
+	stab stabilityInfo) {
+
+	fitChan := make(chan runT, 1)
+	t := threads[0]
+	varSet := make(map[int]struct{})
+	covered := make(map[int]struct{})
+
+	for _, seed := range initSeeds {
+		if seed.crashed || seed.hanged {
+			continue
+		}
+		ss := seedStability{hash: seed.hash, runN: 1}
+		seedVars := make(map[int]struct{})
+		for i := 1; i < stabilityRunN; i++ {
+			runInfo, ok := execOnce(t, seed.input, fitChan)
+			if !ok || runInfo.crashed || runInfo.hanged {
+				continue
+			}
+			ss.runN++
+			for j, tr := range runInfo.trace {
+				if tr != seed.trace[j] {
+					seedVars[j] = struct{}{}
+					varSet[j] = struct{}{}
+				}
+			}
+		}
+
+		for j, tr := range seed.trace {
+			if _, isVar := seedVars[j]; tr != 0 || isVar {
+				ss.edgeN++
+				covered[j] = struct{}{}
+			}
+		}
+		ss.varN = len(seedVars)
+		ss.stabilityPct = stabilityPct(ss.edgeN, ss.varN)
+		stab.seeds = append(stab.seeds, ss)
+	}
+
+	edges := make([]int, 0, len(varSet))
+	for j := range varSet {
+		edges = append(edges, j)
+	}
+	sort.Ints(edges)
+	varEdges = edges
+	stab.edgeN, stab.varN = len(covered), len(varEdges)
+	stab.stabilityPct = stabilityPct(stab.edgeN, stab.varN)
+	fmt.Printf("Stability: %.2f%% (%d variable edges out of %d).\n",
+		stab.stabilityPct, stab.varN, stab.edgeN)
+
+	for _, seed := range initSeeds {
+		if maskTrace(seed.trace) {
+			seed.hash = hashTrBits(seed.trace)
+		}
+	}
+	return stab
+}
+
+func stabilityPct(edgeN, varN int) float64 {
+	if edgeN == 0 {
+		return 100
+	}
+	return 100 * float64(edgeN-varN) / float64(edgeN)
+}
+
+// Zero the variable edges of the trace. Returns true if it changed something
+// (the trace hash needs to be computed again).
+func maskTrace(trace []byte) (changed bool) {
+	for _, j := range varEdges {
+		if trace[j] != 0 {
+			trace[j] = 0
+			changed = true
+		}
+	}
+	return changed
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// Noise of the simulated target is found and masked: the trace hash of a seed
+// doesn't depend on it anymore.
+func TestStabilityMask(t *testing.T) {
+	const noiseN = 2
+	factory := simTargetFactory(t, aflBackend, putOptions{}, "-persistent",
+		"-noise", fmt.Sprint(noiseN))
+	threads, ok := startMultiThreads(1, factory, nil, time.Second)
+	if !ok {
+		t.Fatal("Couldn't start threads.")
+	}
+	defer threads[0].clean()
+	defer func() { varEdges = nil }()
+
+	seedInputs := [][]byte{[]byte("first seed"), []byte("second seed")}
+	initSeeds := execInitSeed(threads, seedInputs)
+	stab := calibrateStability(threads, initSeeds)
+	if stab.varN == 0 || stab.varN > noiseN {
+		t.Fatalf("Found %d variable edges, expected 1 to %d.", stab.varN, noiseN)
+	}
+	if stab.stabilityPct >= 100 {
+		t.Errorf("Stability is %v%% despite noise.", stab.stabilityPct)
+	}
+
+	fitChan := make(chan runT, 1)
+	for _, seed := range initSeeds {
+		for i := 0; i < stabilityRunN; i++ {
+			runInfo, ok := execOnce(threads[0], seed.input, fitChan)
+			if !ok {
+				t.Fatal("Seed execution failed.")
+			}
+			if runInfo.hash != seed.hash {
+				t.Fatalf("Masked trace hash of %q changed.", seed.input)
+			}
+		}
+	}
+}
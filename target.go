@@ -0,0 +1,158 @@
+package main
+
+import (
+	"log"
+
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// *****************************************************************************
+// ******************************** Targets ************************************
+// What a thread executes test cases on. Backends:
+//  - afl: AFL(++) instrumented binary, through its fork server (put_afl.go).
+//  - dumb: non-instrumented binary, fork/exec-ed for each input (put_dumb.go).
+//  - go: in-process Go harness, registered by name (put_go.go).
+
+type target interface {
+	run(testCase []byte, timeout time.Duration) (runInfo runT, err error)
+	getTrace() []byte // Of the last run.
+	clean()
+	capabilities() targetCaps
+}
+
+type targetCaps struct {
+	coverage   bool // Otherwise, the trace is synthetic.
+	persistent bool
+	shmFuzz    bool // Test cases delivered through shared memory.
+	standalone bool // Is a binary the standalone runner can execute.
+}
+
+func (caps targetCaps) String() string {
+	var strs []string
+	if caps.coverage {
+		strs = append(strs, "coverage")
+	}
+	if caps.persistent {
+		strs = append(strs, "persistent")
+	}
+	if caps.shmFuzz {
+		strs = append(strs, "shm fuzzing")
+	}
+	if caps.standalone {
+		strs = append(strs, "standalone")
+	}
+	return strings.Join(strs, ", ")
+}
+
+const (
+	aflBackend  = "afl"
+	dumbBackend = "dumb"
+	goBackend   = "go"
+)
+
+// Starts a new target (at thread start and to restart a dead one).
+type targetFactory func() (tgt target, ok bool)
+
+func makeTargetFactory(backend, binPath string, cliArgs []string,
+	opts putOptions) (factory targetFactory, ok bool) {
+
+	switch backend {
+	case aflBackend:
+		factory = func() (target, bool) {
+			return startAFLPUT(binPath, cliArgs, opts)
+		}
+	case dumbBackend:
+		factory = func() (target, bool) {
+			return startDumbTarget(binPath, cliArgs, opts)
+		}
+	case goBackend:
+		harness, okH := goHarnesses[binPath]
+		if !okH {
+			log.Printf("Unknown Go harness: %s.\n", binPath)
+			return factory, ok
+		}
+		factory = func() (target, bool) { return startGoTarget(harness) }
+	default:
+		log.Printf("Unknown backend: %s.\n", backend)
+		return factory, ok
+	}
+
+	ok = true
+	return factory, ok
+}
+
+// *****************************************************************************
+// **************************** Target Recovery ********************************
+// If the target died (e.g. its fork server, or pipes broke), it is started
+// again with the same configuration and the test case is retried.
+
+const (
+	targetRestartTry  = 3
+	targetRestartWait = 100 * time.Millisecond
+)
+
+var targetRestartN int64 // Total number of target restarts (atomic).
+
+func getTargetRestartN() int64 { return atomic.LoadInt64(&targetRestartN) }
+
+type managedTarget struct {
+	target
+	factory targetFactory
+
+	timeout time.Duration
+	broken  bool // Couldn't be restarted.
+}
+
+func startManagedTarget(factory targetFactory, timeout time.Duration) (
+	mt *managedTarget, ok bool) {
+
+	tgt, ok := factory()
+	if !ok {
+		return mt, ok
+	}
+	mt = &managedTarget{target: tgt, factory: factory, timeout: timeout}
+	return mt, ok
+}
+
+func (mt *managedTarget) safeRun(testCase []byte, timeout time.Duration) (
+	runInfo runT, ok bool) {
+
+	for try := 0; try <= targetRestartTry && !mt.broken; try++ {
+		var err error
+		runInfo, err = mt.run(testCase, timeout)
+		if err == nil {
+			ok = true
+			break
+		} else if try == targetRestartTry { // No other try.
+			break
+		}
+		if try > 0 {
+			time.Sleep(targetRestartWait)
+		}
+		mt.restart()
+	}
+	return runInfo, ok
+}
+
+func (mt *managedTarget) restart() (ok bool) {
+	log.Println("Restarting target.")
+	mt.target.clean()
+	tgt, ok := mt.factory()
+	if !ok {
+		log.Println("Couldn't restart target.")
+		mt.broken = true
+		return ok
+	}
+	mt.target = tgt
+	atomic.AddInt64(&targetRestartN, 1)
+	return ok
+}
+
+// Already cleaned if broken.
+func (mt *managedTarget) clean() {
+	if !mt.broken {
+		mt.target.clean()
+	}
+}
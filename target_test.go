@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// The fork server dies: the test case is retried on a restarted target.
+func TestSafeRunRestart(t *testing.T) {
+	const dieN = 3
+	mt := startSimTarget(t, aflBackend, putOptions{}, "-die", fmt.Sprint(dieN))
+	defer mt.clean()
+
+	input := []byte("restarted test case")
+	runInfo, ok := mt.safeRun(input, mt.timeout)
+	if !ok {
+		t.Fatal("Couldn't run test case.")
+	}
+	hash := runInfo.hash
+	for i := 1; i < dieN; i++ {
+		if _, ok := mt.safeRun([]byte("other test case"), mt.timeout); !ok {
+			t.Fatalf("Couldn't run test case %d.", i)
+		}
+	}
+
+	restartN := getTargetRestartN()
+	runInfo, ok = mt.safeRun(input, mt.timeout)
+	if !ok {
+		t.Fatal("Test case wasn't retried after the fork server died.")
+	} else if runInfo.hash != hash {
+		t.Errorf("Retried test case hash is 0x%x, expected 0x%x.",
+			runInfo.hash, hash)
+	}
+	if n := getTargetRestartN() - restartN; n != 1 {
+		t.Errorf("%d restarts, expected 1.", n)
+	}
+	if mt.broken {
+		t.Error("Restarted target is broken.")
+	}
+}
+
+// The target can't be restarted: it is broken, and not tried again.
+func TestSafeRunBroken(t *testing.T) {
+	simFactory := simTargetFactory(t, aflBackend, putOptions{}, "-die", "1")
+	var factoryN int
+	factory := func() (tgt target, ok bool) {
+		factoryN++
+		if factoryN > 1 {
+			return tgt, ok
+		}
+		return simFactory()
+	}
+	mt, ok := startManagedTarget(factory, time.Second)
+	if !ok {
+		t.Fatal("Couldn't start target.")
+	}
+	defer mt.clean()
+
+	if _, ok := mt.safeRun([]byte("first"), mt.timeout); !ok {
+		t.Fatal("Couldn't run first test case.")
+	}
+	restartN := getTargetRestartN()
+	execStartT := time.Now()
+	if _, ok := mt.safeRun([]byte("second"), mt.timeout); ok {
+		t.Error("Test case ran on a dead target.")
+	} else if !mt.broken {
+		t.Error("Target not marked broken.")
+	}
+	if _, ok := mt.safeRun([]byte("third"), mt.timeout); ok {
+		t.Error("Test case ran on a broken target.")
+	}
+	if factoryN != 2 {
+		t.Errorf("Factory called %d times, expected 2.", factoryN)
+	} else if d := time.Since(execStartT); d >= targetRestartWait {
+		t.Errorf("Broken target took %v to give up.", d)
+	}
+	if getTargetRestartN() != restartN {
+		t.Error("Failed restart counted.")
+	}
+}
+
+// The fork server stops replying: the test case is retried on a restarted
+// target instead of blocking the thread.
+func TestSafeRunWedged(t *testing.T) {
+	mt := startSimTarget(t, aflBackend, putOptions{}, "-wedge", "1")
+	defer mt.clean()
+
+	if _, ok := mt.safeRun([]byte("first"), mt.timeout); !ok {
+		t.Fatal("Couldn't run first test case.")
+	}
+	restartN := getTargetRestartN()
+	execStartT := time.Now()
+	if _, ok := mt.safeRun([]byte("second"), mt.timeout); !ok {
+		t.Fatal("Test case wasn't retried after the fork server stopped.")
+	}
+	if n := getTargetRestartN() - restartN; n != 1 {
+		t.Errorf("%d restarts, expected 1.", n)
+	} else if d := time.Since(execStartT); d > 2*forksrvReplyTimeout {
+		t.Errorf("Stopped fork server detected after %v.", d)
+	}
+}
+
+type deadTarget struct{}
+
+func (deadTarget) run(testCase []byte, timeout time.Duration) (runT, error) {
+	return runT{}, errors.New("dead target")
+}
+func (deadTarget) getTrace() []byte         { return nil }
+func (deadTarget) clean()                   {}
+func (deadTarget) capabilities() targetCaps { return targetCaps{} }
+
+// The target is only restarted when another try follows.
+func TestSafeRunTries(t *testing.T) {
+	var factoryN int
+	factory := func() (target, bool) { factoryN++; return deadTarget{}, true }
+	mt, ok := startManagedTarget(factory, time.Second)
+	if !ok {
+		t.Fatal("Couldn't start target.")
+	}
+
+	restartN := getTargetRestartN()
+	if _, ok := mt.safeRun([]byte("test case"), mt.timeout); ok {
+		t.Error("Test case ran on a dead target.")
+	}
+	if factoryN != 1+targetRestartTry {
+		t.Errorf("Factory called %d times, expected %d.", factoryN,
+			1+targetRestartTry)
+	} else if n := getTargetRestartN() - restartN; n != targetRestartTry {
+		t.Errorf("%d restarts, expected %d.", n, targetRestartTry)
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"flag"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// *****************************************************************************
+// ************************** Test Case Minimization ***************************
+// "hemipt tmin": shrink an input (AFL-tmin like) while it keeps its trace hash,
+// its edge set or its crash signal. Passes (block deletion, then byte
+// normalization) are repeated until none makes progress.
+
+const (
+	tminCmd       = "tmin"
+	tminTimeout   = time.Second
+	tminMaxRounds = 16
+	tminNormByte  = '0' // Bytes are normalized to this one.
+
+	tminModeHash  = "hash"
+	tminModeEdges = "edges"
+	tminModeCrash = "crash"
+)
+
+// ** Command-line of the tools (tmin, cmin) **
+
+type toolConfig struct {
+	cliStr    string
+	putOpts   putOptions
+	timeoutMs int
+	randSeed  int64 // 0: from the clock.
+}
+
+func addToolFlags(fs *flag.FlagSet, cfg *toolConfig) {
+	fs.StringVar(&cfg.cliStr, "cli", "", "PUT command-line interface")
+	fs.IntVar(&cfg.timeoutMs, "t", int(tminTimeout/time.Millisecond),
+		"Timeout of a PUT execution in ms")
+	fs.BoolVar(&cfg.putOpts.noShmFuzz, "noshmfuzz", false,
+		"Don't deliver test cases through shared memory, even if the PUT can")
+	fs.Uint64Var(&cfg.putOpts.memLimit, "m", 0,
+		"Memory limit (address space) of the PUT in MB (0: none)")
+	fs.Uint64Var(&cfg.putOpts.fsizeLimit, "fsize", 0,
+		"Limit of the size of the files the PUT creates in MB (0: none)")
+	fs.BoolVar(&cfg.putOpts.sandbox, "sandbox", false,
+		"Run the PUT in new user/mount/network namespaces")
+	fs.Int64Var(&cfg.randSeed, "seed", 0,
+		"Seed of the random number generators, to reproduce a run "+
+			"(default: from the clock)")
+}
+
+func (cfg toolConfig) startTarget() (mt *managedTarget, ok bool) {
+	if len(cfg.cliStr) == 0 {
+		log.Println("Please provide CLI argument.")
+		return mt, ok
+	}
+	putArgs := strings.Split(cfg.cliStr, " ")
+	cfg.putOpts.timeout = time.Duration(cfg.timeoutMs) * time.Millisecond
+	factory, _ := makeTargetFactory(aflBackend, putArgs[0], putArgs[1:],
+		cfg.putOpts)
+	return startManagedTarget(factory, cfg.putOpts.timeout)
+}
+
+// ** Minimization **
+
+type tminStats struct {
+	origLen, minLen int
+	execN, roundN   int
+	duration        time.Duration
+}
+
+func (stats tminStats) lines(mode string) []string {
+	reduction := 0.0
+	if stats.origLen > 0 {
+		reduction = 100 * float64(stats.origLen-stats.minLen) /
+			float64(stats.origLen)
+	}
+	return []string{
+		fmt.Sprintf("mode: %s", mode),
+		fmt.Sprintf("original_len: %d", stats.origLen),
+		fmt.Sprintf("minimized_len: %d", stats.minLen),
+		fmt.Sprintf("reduction: %.1f%%", reduction),
+		fmt.Sprintf("exec_n: %d", stats.execN),
+		fmt.Sprintf("round_n: %d", stats.roundN),
+		fmt.Sprintf("duration: %v", stats.duration),
+	}
+}
+
+func tmin(args []string) {
+	var cfg toolConfig
+	fs := flag.NewFlagSet(tminCmd, flag.ExitOnError)
+	addToolFlags(fs, &cfg)
+	inPath := fs.String("i", "", "Input to minimize")
+	outPath := fs.String("o", "", "Minimized input")
+	mode := fs.String("mode", tminModeHash, fmt.Sprintf(
+		"What to preserve: %s (trace hash), %s (set of edges) or %s "+
+			"(crash signal)", tminModeHash, tminModeEdges, tminModeCrash))
+	fs.Parse(args)
+	if len(*inPath) == 0 || len(*outPath) == 0 {
+		fs.Usage()
+		log.Fatal("Please provide input and output paths.")
+	}
+	setCampaignSeed(cfg.randSeed)
+
+	input, err := ioutil.ReadFile(*inPath)
+	if err != nil {
+		log.Fatalf("Couldn't read input: %v.\n", err)
+	}
+	mt, ok := cfg.startTarget()
+	if !ok {
+		log.Fatal("Couldn't start the PUT.")
+	}
+	defer mt.clean()
+
+	minimized, stats, ok := minimizeInput(mt, input, *mode)
+	if !ok {
+		return
+	}
+	err = ioutil.WriteFile(*outPath, minimized, 0644)
+	if err != nil {
+		log.Printf("Couldn't write minimized input: %v.\n", err)
+		return
+	}
+	statLines := stats.lines(*mode)
+	saveLines(*outPath+".meta", statLines)
+	fmt.Println(strings.Join(statLines, "\n"))
+}
+
+func minimizeInput(mt *managedTarget, input []byte, mode string) (
+	minimized []byte, stats tminStats, ok bool) {
+
+	execStartT := time.Now()
+	ref, okRun := mt.safeRun(input, mt.timeout)
+	if !okRun || ref.hanged {
+		log.Println("Original input couldn't run (or hanged).")
+		return minimized, stats, ok
+	}
+	refTrace := append([]byte{}, mt.getTrace()...)
+
+	var keeps func(runInfo runT) bool
+	switch mode {
+	case tminModeHash:
+		keeps = func(runInfo runT) bool {
+			return runInfo.hash == ref.hash && runInfo.crashed == ref.crashed
+		}
+	case tminModeEdges:
+		keeps = func(runInfo runT) bool {
+			return runInfo.crashed == ref.crashed &&
+				sameEdges(refTrace, mt.getTrace())
+		}
+	case tminModeCrash:
+		if !ref.crashed {
+			log.Println("Original input doesn't crash.")
+			return minimized, stats, ok
+		}
+		keeps = func(runInfo runT) bool {
+			return runInfo.crashed && runInfo.sig == ref.sig
+		}
+	default:
+		log.Printf("Unknown minimization mode: %s.\n", mode)
+		return minimized, stats, ok
+	}
+
+	stats.origLen = len(input)
+	try := func(candidate []byte) bool {
+		stats.execN++
+		runInfo, okRun := mt.safeRun(candidate, mt.timeout)
+		return okRun && !runInfo.hanged && keeps(runInfo)
+	}
+
+	minimized = append([]byte{}, input...)
+	for changed := true; changed && stats.roundN < tminMaxRounds; {
+		stats.roundN++
+		var delChanged, normChanged bool
+		minimized, delChanged = tminDeleteBlocks(minimized, try)
+		minimized, normChanged = tminNormalize(minimized, try)
+		changed = delChanged || normChanged
+	}
+
+	stats.minLen = len(minimized)
+	stats.duration = time.Since(execStartT)
+	ok = true
+	return minimized, stats, ok
+}
+
+func sameEdges(trace1, trace2 []byte) bool {
+	for i, tr := range trace1 {
+		if (tr == 0) != (trace2[i] == 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// Delete blocks of decreasing sizes (len/16 first).
+func tminDeleteBlocks(input []byte, try func([]byte) bool) (
+	out []byte, changed bool) {
+
+	delLen := 1
+	for delLen*16 < len(input) {
+		delLen *= 2
+	}
+	for ; delLen > 0; delLen /= 2 {
+		for pos := 0; pos < len(input); {
+			end := pos + delLen
+			if end > len(input) {
+				end = len(input)
+			}
+			candidate := make([]byte, 0, len(input)-(end-pos))
+			candidate = append(candidate, input[:pos]...)
+			candidate = append(candidate, input[end:]...)
+			if try(candidate) {
+				input, changed = candidate, true
+			} else {
+				pos += delLen
+			}
+		}
+	}
+	return input, changed
+}
+
+// Replace all the occurrences of a byte value at once, then bytes one by one.
+func tminNormalize(input []byte, try func([]byte) bool) (
+	out []byte, changed bool) {
+
+	var present [0x100]bool
+	for _, b := range input {
+		present[b] = true
+	}
+	for v := 0; v < 0x100; v++ {
+		if !present[v] || v == tminNormByte {
+			continue
+		}
+		candidate := make([]byte, len(input))
+		for i, b := range input {
+			candidate[i] = b
+			if b == byte(v) {
+				candidate[i] = tminNormByte
+			}
+		}
+		if try(candidate) {
+			input, changed = candidate, true
+		}
+	}
+
+	for i := range input {
+		if input[i] == tminNormByte {
+			continue
+		}
+		candidate := append([]byte{}, input...)
+		candidate[i] = tminNormByte
+		if try(candidate) {
+			input, changed = candidate, true
+		}
+	}
+	return input, changed
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestMinimizeInput(t *testing.T) {
+	mt := startSimTarget(t, aflBackend, putOptions{}, "-persistent",
+		"-depth", "4")
+	defer mt.clean()
+
+	tests := []struct {
+		mode, input string
+		minLen      int
+	}{
+		{tminModeCrash, "CRASH and useless bytes", 2},
+		{tminModeHash, "hello world", 4}, // Only 4 bytes have branches.
+		{tminModeEdges, "HELLO WORLD", 4},
+	}
+	for _, test := range tests {
+		ref, _ := mt.safeRun([]byte(test.input), mt.timeout)
+		refTrace := append([]byte{}, mt.getTrace()...)
+		minimized, stats, ok := minimizeInput(mt, []byte(test.input), test.mode)
+		if !ok {
+			t.Fatalf("Couldn't minimize %q (%s).", test.input, test.mode)
+		}
+		if len(minimized) != test.minLen {
+			t.Errorf("%q minimized to %q (%s), expected %d bytes.", test.input,
+				minimized, test.mode, test.minLen)
+		}
+		if stats.origLen != len(test.input) || stats.minLen != len(minimized) {
+			t.Errorf("Wrong statistics: %+v.", stats)
+		}
+
+		// The minimized input still has what the mode keeps.
+		runInfo, _ := mt.safeRun(minimized, mt.timeout)
+		var kept bool
+		switch test.mode {
+		case tminModeCrash:
+			kept = runInfo.crashed && runInfo.sig == ref.sig
+		case tminModeHash:
+			kept = !runInfo.crashed && runInfo.hash == ref.hash
+		case tminModeEdges:
+			kept = !runInfo.crashed && sameEdges(refTrace, mt.getTrace())
+		}
+		if !kept {
+			t.Errorf("%q (%s): minimized %q doesn't behave like it.",
+				test.input, test.mode, minimized)
+		}
+	}
+
+	if _, _, ok := minimizeInput(mt, []byte("no crash"), tminModeCrash); ok {
+		t.Error("Non-crashing input minimized in crash mode.")
+	}
+}